@@ -7,8 +7,11 @@ if [ ! -d "bin" ]; then
     mkdir bin
 fi
 
-# Compile source files
-go build .
+# Compile source files, embedding version information for -version
+VERSION=$(git describe --tags --always --dirty 2>/dev/null || echo dev)
+COMMIT=$(git rev-parse --short HEAD 2>/dev/null || echo unknown)
+DATE=$(date -u +%Y-%m-%dT%H:%M:%SZ)
+go build -ldflags "-X main.version=${VERSION} -X main.commit=${COMMIT} -X main.date=${DATE}" .
 
 # Move executable to bin directory
 mv goldmine-connect bin/
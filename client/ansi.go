@@ -0,0 +1,98 @@
+package client
+
+import "io"
+
+// ANSI stripper states.
+const (
+	ansiText = iota
+	ansiEscape
+	ansiCSI
+	ansiOSC
+	ansiOSCEscape
+)
+
+// ansiStripper removes ANSI escape sequences (CSI, OSC and two-byte ESC
+// sequences) before writing to the underlying writer, leaving printable text,
+// tabs and line breaks. State is kept between writes so sequences split
+// across reads are removed correctly.
+type ansiStripper struct {
+	writer io.Writer
+	state  int
+}
+
+func newANSIStripper(w io.Writer) *ansiStripper {
+	return &ansiStripper{writer: w}
+}
+
+// Write strips escape sequences from p and writes the remaining text.
+func (s *ansiStripper) Write(p []byte) (int, error) {
+	out := make([]byte, 0, len(p))
+
+	for _, b := range p {
+		switch s.state {
+		case ansiText:
+			switch {
+			case b == 0x1b:
+				s.state = ansiEscape
+			case b == '\n' || b == '\r' || b == '\t' || b >= 0x20 && b != 0x7f:
+				out = append(out, b)
+			}
+		case ansiEscape:
+			switch b {
+			case '[':
+				s.state = ansiCSI
+			case ']':
+				s.state = ansiOSC
+			default:
+				// Two-byte sequence such as ESC 7 or ESC M
+				s.state = ansiText
+			}
+		case ansiCSI:
+			// Parameter and intermediate bytes run until a final byte in 0x40-0x7E
+			if b >= 0x40 && b <= 0x7e {
+				s.state = ansiText
+			}
+		case ansiOSC:
+			switch b {
+			case 0x07:
+				s.state = ansiText
+			case 0x1b:
+				s.state = ansiOSCEscape
+			}
+		case ansiOSCEscape:
+			// ESC \ terminates the OSC string
+			if b == '\\' {
+				s.state = ansiText
+			} else {
+				s.state = ansiOSC
+			}
+		}
+	}
+
+	if len(out) > 0 {
+		if _, err := s.writer.Write(out); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// clearScreen erases the whole screen and homes the cursor.
+var clearScreen = []byte("\x1b[2J\x1b[H")
+
+// screenClearer clears the screen ahead of the first output written through
+// it, so a banner disappears once the server starts drawing.
+type screenClearer struct {
+	writer  io.Writer
+	cleared bool
+}
+
+func (c *screenClearer) Write(p []byte) (int, error) {
+	if !c.cleared && len(p) > 0 {
+		c.cleared = true
+		if _, err := c.writer.Write(clearScreen); err != nil {
+			return 0, err
+		}
+	}
+	return c.writer.Write(p)
+}
@@ -0,0 +1,155 @@
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// castHeader is the first line of an asciicast v2 file.
+type castHeader struct {
+	Version   int   `json:"version"`
+	Width     int   `json:"width"`
+	Height    int   `json:"height"`
+	Timestamp int64 `json:"timestamp"`
+}
+
+// castRecorder writes terminal output as an asciicast v2 (.cast) file.
+type castRecorder struct {
+	file   *os.File
+	writer *bufio.Writer
+	start  time.Time
+	last   float64 // offset of the latest event, so offsets never go back
+}
+
+// newCastRecorder creates the file at path and writes the asciicast header.
+func newCastRecorder(path string, width, height int) (*castRecorder, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &castRecorder{
+		file:   file,
+		writer: bufio.NewWriter(file),
+		start:  time.Now(),
+	}
+
+	header, _ := json.Marshal(castHeader{
+		Version:   2,
+		Width:     width,
+		Height:    height,
+		Timestamp: r.start.Unix(),
+	})
+	if err := r.writeLine(header); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+// Write records p as an output event stamped with the time since the recording started.
+func (r *castRecorder) Write(p []byte) (int, error) {
+	if err := r.writeEvent("o", string(p)); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Mark records a marker event, which players show as a point to jump to.
+func (r *castRecorder) Mark(label string) error {
+	return r.writeEvent("m", label)
+}
+
+func (r *castRecorder) writeEvent(kind, data string) error {
+	elapsed := time.Since(r.start).Seconds()
+	if elapsed < r.last {
+		elapsed = r.last
+	}
+	r.last = elapsed
+
+	event, err := json.Marshal([]interface{}{elapsed, kind, data})
+	if err != nil {
+		return err
+	}
+	return r.writeLine(event)
+}
+
+func (r *castRecorder) writeLine(line []byte) error {
+	if _, err := r.writer.Write(line); err != nil {
+		return err
+	}
+	return r.writer.WriteByte('\n')
+}
+
+// Close flushes any buffered events and closes the file.
+func (r *castRecorder) Close() error {
+	if err := r.writer.Flush(); err != nil {
+		r.file.Close()
+		return err
+	}
+	return r.file.Close()
+}
+
+// PlayCast writes the output events of an asciicast v2 file to out, waiting
+// between events as recorded. The delays are divided by speed; a speed of 0
+// plays the recording instantly. Events before from are written straight
+// away, so the screen is drawn as it was at that point before playback
+// carries on in time.
+func PlayCast(path string, out io.Writer, speed float64, from time.Duration) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+
+	line, err := reader.ReadBytes('\n')
+	if err != nil && err != io.EOF {
+		return err
+	}
+	var header castHeader
+	if err := json.Unmarshal(line, &header); err != nil {
+		return fmt.Errorf("invalid asciicast header: %v", err)
+	}
+	if header.Version != 2 {
+		return fmt.Errorf("unsupported asciicast version %d", header.Version)
+	}
+
+	previous := from.Seconds()
+	for lineNumber := 2; ; lineNumber++ {
+		line, err := reader.ReadBytes('\n')
+		if len(bytes.TrimSpace(line)) > 0 {
+			var event []interface{}
+			if jsonErr := json.Unmarshal(line, &event); jsonErr != nil || len(event) != 3 {
+				return fmt.Errorf("invalid asciicast event on line %d", lineNumber)
+			}
+			offset, _ := event[0].(float64)
+			kind, _ := event[1].(string)
+			data, _ := event[2].(string)
+
+			if kind == "o" {
+				if offset > previous {
+					if speed > 0 {
+						time.Sleep(time.Duration((offset - previous) / speed * float64(time.Second)))
+					}
+					previous = offset
+				}
+				if _, err := io.WriteString(out, data); err != nil {
+					return err
+				}
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
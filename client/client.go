@@ -0,0 +1,1605 @@
+// Package client connects to a GoldMine door server over rlogin (or plain
+// telnet), runs the session between a local input and output, and reports
+// how it ended. It is the engine behind the goldmine-connect command;
+// NewOptions and its With functions configure a TelnetClient the same way
+// the command's flags do.
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"net"
+	"net/url"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/proxy"
+	"golang.org/x/term"
+)
+
+// DefaultBufferSize is the read buffer size NewOptions starts with; the
+// -bufsize flag must be between MinBufferSize and MaxBufferSize.
+const (
+	DefaultBufferSize = 4096
+	MinBufferSize     = 512
+	MaxBufferSize     = 1 << 20
+)
+
+const sleepBufferFullMilli = 250
+const defaultCols = 80
+const defaultRows = 24
+const maxRetryDelay = 30 * time.Second
+
+// dnsRetryDelay is the wait before the first -dns-retries lookup, doubled
+// after each one.
+const dnsRetryDelay = 500 * time.Millisecond
+
+// ackWindow is how long -skip-ack waits for the handshake acknowledgement.
+const ackWindow = 500 * time.Millisecond
+
+// errHandshakeRejected is returned by ProcessData when the server hangs up
+// straight after the handshake and there is another template to try.
+var errHandshakeRejected error = &handshakeError{errors.New("server rejected the handshake")}
+
+// errNoHandshakeResponse is returned by ProcessData when the server accepts
+// the connection but sends nothing back after the rlogin handshake.
+var errNoHandshakeResponse error = &handshakeError{errors.New("no response to handshake - wrong port or service?")}
+
+// retryableError marks connection-level failures that a reconnect may fix.
+type retryableError struct {
+	err error
+}
+
+func (e *retryableError) Error() string { return e.err.Error() }
+func (e *retryableError) Unwrap() error { return e.err }
+
+// SessionStats describes the data transferred during a session. Byte counts
+// cover the application payload only, not telnet negotiation or the handshake.
+type SessionStats struct {
+	BytesSent      int64
+	BytesReceived  int64
+	Duration       time.Duration
+	ReconnectCount int
+}
+
+// add accumulates the counters of another connection into s.
+func (s *SessionStats) add(other SessionStats) {
+	s.BytesSent += other.BytesSent
+	s.BytesReceived += other.BytesReceived
+	s.Duration += other.Duration
+}
+
+// String returns a one-line summary of the session.
+func (s SessionStats) String() string {
+	return fmt.Sprintf("sent %d bytes, received %d bytes in %v, %d reconnects",
+		s.BytesSent, s.BytesReceived, s.Duration.Round(time.Second), s.ReconnectCount)
+}
+
+// TelnetClient represents a TCP client which is responsible for writing input data and printing response.
+type TelnetClient struct {
+	options         Options
+	targets         []serverTarget
+	network         string // network, address and destination are those
+	address         string // of the target last dialed
+	destination     *net.TCPAddr
+	proxy           string
+	responseTimeout time.Duration
+	termType        string
+	cols            int
+	rows            int
+	retries         int
+	retryDelay      time.Duration
+	encoding        string
+	record          string
+	bufferSize      int
+	keepAlive       time.Duration
+	idleTimeout     time.Duration
+	tlsConfig       *tls.Config
+	debugLog        *log.Logger
+	logger          *log.Logger
+	structured      *slog.Logger // replaces logger for -log-format json
+	metrics         *SessionMetrics
+	quiet           bool
+	script          []scriptStep
+	scriptTimeout   time.Duration
+	plain           bool
+	emulateBaud     int
+	connectTimeout  time.Duration
+	noCompress      bool
+	escape          string
+	pasteDelay      time.Duration
+	pasteChunk      int
+	bracketedPaste  bool
+
+	// input reads inputData across sessions; see inputPump.
+	inputMu           sync.Mutex
+	input             *inputPump
+	logFile           string
+	rloginStrict      bool
+	antiIdle          time.Duration
+	antiIdleBytes     string
+	rawTelnet         bool
+	secret            string
+	captureScreens    string
+	initText          string
+	initOnReconnect   bool
+	noZmodemDetect    bool
+	zmodemRz          string
+	zmodemDownloadDir string
+	zmodemSz          string
+	zmodemUpload      string
+	crlf              string
+	localEcho         bool
+	bannerFile        string
+	clearOnConnect    bool
+	traceFile         string
+	rejectWindow      time.Duration
+	timeoutAction     string
+	typeDelay         time.Duration
+	rloginWinch       bool
+	skipAck           bool
+	sessionLogDir     string
+	sessionLogKeep    int
+	mirror            string
+	maxDuration       time.Duration
+	maxDurationWarn   time.Duration
+	xtrnSequence      string
+	stallTimeout      time.Duration
+	colorDowngrade    string
+
+	// localAddr is the -bind address, or nil to let the system choose.
+	localAddr    *net.TCPAddr
+	wrap         int
+	failOn       string
+	failOnWindow time.Duration
+
+	// initSent records that -init went out, so reconnects skip it.
+	initSent bool
+
+	// handshakes are the templates to try in turn, "" being the built-in
+	// layout. The one at handshakeIndex is in use, and handshakeSent is
+	// when it last went out.
+	handshakes     []string
+	handshakeIndex int
+	handshakeSent  time.Time
+
+	// recorder is the -record file. Run keeps it open across reconnects,
+	// with a marker at each, so a dropped session plays back as one.
+	recorder     *castRecorder
+	keepRecorder bool
+
+	// rawTerminal is set when the local terminal is in raw mode and so
+	// doesn't echo typing itself; see SetRawTerminal.
+	rawTerminal bool
+
+	// dialer opens the server connection. It defaults to dial, and can be
+	// replaced to run a session over any net.Conn, such as a net.Pipe.
+	dialer func() (net.Conn, error)
+}
+
+// NewTelnetClient creates a new TelnetClient instance.
+func NewTelnetClient(options Options) (*TelnetClient, error) {
+	var tlsConfig *tls.Config
+	if options.TLS() {
+		tlsConfig = &tls.Config{
+			ServerName:         options.TLSServerName(),
+			InsecureSkipVerify: options.TLSInsecure(),
+		}
+	}
+
+	handshakes := []string{options.HandshakeTemplate()}
+	if options.HandshakeAlternates() != "" {
+		alternates, err := loadHandshakeTemplates(options.HandshakeAlternates())
+		if err != nil {
+			return nil, err
+		}
+		handshakes = append(handshakes, alternates...)
+	}
+
+	var localAddr *net.TCPAddr
+	if options.Bind() != "" {
+		var err error
+		localAddr, err = resolveBindAddr(options.Bind())
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var script []scriptStep
+	if options.Script() != "" {
+		var err error
+		script, err = loadScript(options.Script())
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	client := &TelnetClient{
+		proxy:             options.Proxy(),
+		responseTimeout:   options.Timeout(),
+		termType:          options.TermType(),
+		cols:              options.Cols(),
+		rows:              options.Rows(),
+		retries:           options.Retries(),
+		retryDelay:        options.RetryDelay(),
+		encoding:          options.Encoding(),
+		record:            options.Record(),
+		bufferSize:        options.BufferSize(),
+		keepAlive:         options.KeepAlive(),
+		idleTimeout:       options.IdleTimeout(),
+		tlsConfig:         tlsConfig,
+		script:            script,
+		scriptTimeout:     options.ScriptTimeout(),
+		plain:             options.Plain(),
+		emulateBaud:       options.EmulateBaud(),
+		connectTimeout:    options.ConnectTimeout(),
+		noCompress:        options.NoCompress(),
+		escape:            options.Escape(),
+		pasteDelay:        options.PasteDelay(),
+		pasteChunk:        options.PasteChunk(),
+		bracketedPaste:    options.BracketedPaste(),
+		logFile:           options.LogFile(),
+		rloginStrict:      options.RloginStrict(),
+		antiIdle:          options.AntiIdle(),
+		antiIdleBytes:     options.AntiIdleBytes(),
+		rawTelnet:         options.RawTelnet(),
+		secret:            options.Secret(),
+		captureScreens:    options.CaptureScreens(),
+		initText:          options.Init(),
+		initOnReconnect:   options.InitOnReconnect(),
+		noZmodemDetect:    options.NoZmodemDetect(),
+		zmodemRz:          options.ZmodemRz(),
+		zmodemDownloadDir: options.ZmodemDownloadDir(),
+		zmodemSz:          options.ZmodemSz(),
+		zmodemUpload:      options.ZmodemUpload(),
+		crlf:              options.CRLF(),
+		localEcho:         options.LocalEcho(),
+		bannerFile:        options.BannerFile(),
+		clearOnConnect:    options.ClearOnConnect(),
+		traceFile:         options.TraceFile(),
+		rejectWindow:      options.RejectWindow(),
+		handshakes:        handshakes,
+		timeoutAction:     options.TimeoutAction(),
+		typeDelay:         options.TypeDelay(),
+		rloginWinch:       options.RloginWinch(),
+		skipAck:           options.SkipAck(),
+		sessionLogDir:     options.SessionLogDir(),
+		sessionLogKeep:    options.SessionLogKeep(),
+		mirror:            options.Mirror(),
+		maxDuration:       options.MaxDuration(),
+		maxDurationWarn:   options.MaxDurationWarn(),
+		xtrnSequence:      options.XtrnSequence(),
+		stallTimeout:      options.StallTimeout(),
+		colorDowngrade:    options.ColorDowngrade(),
+		wrap:              options.Wrap(),
+		failOn:            options.FailOn(),
+		failOnWindow:      options.FailOnWindow(),
+		options:           options,
+		localAddr:         localAddr,
+	}
+	client.dialer = client.dial
+	client.logger = log.New(os.Stderr, "", log.LstdFlags)
+	client.quiet = options.Quiet()
+
+	targets, err := client.resolveTargets(context.Background(), options.Host(), options.Port(), options.Network(), options.DNSRetries())
+	if err != nil {
+		return nil, err
+	}
+	client.targets = targets
+	client.network, client.address, client.destination = targets[0].network, targets[0].address, targets[0].destination
+
+	if options.Debug() {
+		client.debugLog = log.New(os.Stderr, "DEBUG ", log.LstdFlags|log.Lmicroseconds)
+	}
+
+	return client, nil
+}
+
+// SetLogger replaces the logger used for operational messages. These never
+// go to the session output, so they can't corrupt the BBS screen.
+func (t *TelnetClient) SetLogger(logger *log.Logger) {
+	t.logger = logger
+}
+
+// SetMetrics makes the client keep m up to date as the session runs.
+func (t *TelnetClient) SetMetrics(m *SessionMetrics) {
+	t.metrics = m
+}
+
+// SetRawTerminal tells the client whether the local terminal is in raw
+// mode, in which case typed characters are only seen if someone echoes them.
+func (t *TelnetClient) SetRawTerminal(raw bool) {
+	t.rawTerminal = raw
+}
+
+// SetStructuredLogger sends operational messages to logger as structured
+// records instead of lines of text, and enables events that carry fields.
+func (t *TelnetClient) SetStructuredLogger(logger *slog.Logger) {
+	t.structured = logger
+}
+
+// infof logs an informational message unless -quiet is set.
+func (t *TelnetClient) infof(format string, v ...interface{}) {
+	if t.quiet {
+		return
+	}
+	if t.structured != nil {
+		t.structured.Info(fmt.Sprintf(format, v...))
+		return
+	}
+	t.logger.Printf(format, v...)
+}
+
+// errorf logs an error message; these are shown even with -quiet.
+func (t *TelnetClient) errorf(format string, v ...interface{}) {
+	if t.structured != nil {
+		t.structured.Error(fmt.Sprintf(format, v...))
+		return
+	}
+	t.logger.Printf(format, v...)
+}
+
+// logEvent records an event with fields for structured logging. Text logs
+// leave these out; the infof messages already tell an interactive user.
+func (t *TelnetClient) logEvent(msg string, args ...any) {
+	if t.structured != nil && !t.quiet {
+		t.structured.Info(msg, args...)
+	}
+}
+
+// Run calls ProcessData, reconnecting with exponential backoff after
+// connection-level failures until the configured retries are used up.
+// With -xtrn-sequence it does so for each door in turn. The returned stats
+// cover all connections made.
+func (t *TelnetClient) Run(ctx context.Context, inputData io.Reader, outputData io.Writer) (SessionStats, error) {
+	var total SessionStats
+
+	t.keepRecorder = true
+	defer func() {
+		t.closeRecorder()
+		t.keepRecorder = false
+	}()
+
+	// The banner is shown once, while the first connection is made
+	if t.bannerFile != "" {
+		banner, err := os.ReadFile(t.bannerFile)
+		if err != nil {
+			return total, fmt.Errorf("failed to read banner file %q: %v", t.bannerFile, err)
+		}
+		bannerOutput := outputData
+		if t.encoding == "cp437" {
+			// ANSI art is normally drawn in CP437 like the board itself
+			bannerOutput = newCP437Writer(outputData)
+		}
+		bannerOutput.Write(banner)
+	}
+
+	if t.xtrnSequence == "" {
+		return t.runWithRetries(ctx, inputData, outputData)
+	}
+
+	// GoldMine hangs up when the door picked in the handshake exits, so
+	// each door in -xtrn-sequence gets a session of its own
+	base := t.options
+	defer func() { t.options = base }()
+	codes := strings.Split(t.xtrnSequence, ",")
+	for i, code := range codes {
+		t.options = xtrnOptions{Options: base, xtrn: code}
+		t.infof("Opening door %q (%d of %d).", code, i+1, len(codes))
+		stats, err := t.runWithRetries(ctx, inputData, outputData)
+		total.add(stats)
+		if err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
+
+// runWithRetries runs a session, reconnecting as -retries allows.
+func (t *TelnetClient) runWithRetries(ctx context.Context, inputData io.Reader, outputData io.Writer) (SessionStats, error) {
+	var total SessionStats
+	delay := t.retryDelay
+
+	for attempt := 1; ; attempt++ {
+		stats, err := t.ProcessDataContext(ctx, inputData, outputData)
+		total.add(stats)
+
+		// A rejected handshake is retried straight away with the next
+		// template, without using up -retries
+		if errors.Is(err, errHandshakeRejected) {
+			total.ReconnectCount++
+			t.metrics.addReconnect()
+			attempt--
+			continue
+		}
+
+		var retryable *retryableError
+		if !errors.As(err, &retryable) {
+			return total, err
+		}
+		// Reconnecting is the point of -stall-timeout, so a stall gets
+		// one reconnect even when -retries is 0
+		retries := t.retries
+		if errors.Is(err, errStalled) {
+			retries = max(retries, 1)
+		}
+		if attempt > retries {
+			if errors.Is(err, ErrServerClosed) {
+				// A server hang-up is a normal end of session
+				return total, nil
+			}
+			return total, err
+		}
+
+		t.infof("Reconnecting in %v (attempt %d of %d): %v", delay, attempt, retries, err)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return total, ctx.Err()
+		}
+		total.ReconnectCount++
+		t.metrics.addReconnect()
+		t.reresolveTargets(ctx)
+
+		delay *= 2
+		if delay > maxRetryDelay {
+			delay = maxRetryDelay
+		}
+	}
+}
+
+// openRecorder returns the -record recorder, creating the file for the
+// first connection and marking the reconnect on later ones.
+func (t *TelnetClient) openRecorder() (*castRecorder, error) {
+	if t.recorder != nil {
+		return t.recorder, t.recorder.Mark("Reconnected to " + t.address)
+	}
+	cols, rows := t.windowSize()
+	recorder, err := newCastRecorder(t.record, cols, rows)
+	if err != nil {
+		return nil, err
+	}
+	t.recorder = recorder
+	return recorder, nil
+}
+
+// closeRecorder finishes the -record file, if one is open.
+func (t *TelnetClient) closeRecorder() {
+	if t.recorder != nil {
+		t.recorder.Close()
+		t.recorder = nil
+	}
+}
+
+// ProcessData method establishes a connection to the server and processes input/output data,
+// using the options the client was created with.
+// It returns an error if the connection, handshake, or data transfer fails.
+func (t *TelnetClient) ProcessData(inputData io.Reader, outputData io.Writer) (SessionStats, error) {
+	return t.ProcessDataContext(context.Background(), inputData, outputData)
+}
+
+// ProcessDataContext is like ProcessData but closes the connection and
+// returns ctx.Err() as soon as ctx is cancelled.
+func (t *TelnetClient) ProcessDataContext(ctx context.Context, inputData io.Reader, outputData io.Writer) (stats SessionStats, err error) {
+	connection, err := t.dialer()
+	if err != nil {
+		return stats, &retryableError{&connectError{address: t.address, err: err}}
+	}
+
+	t.logEvent("connected", "address", t.address)
+	t.metrics.opened()
+	defer t.metrics.closed()
+
+	// The trace sees the wire bytes, so it wraps the connection first
+	if t.traceFile != "" {
+		trace, err := openTraceFile(t.traceFile, t.address)
+		if err != nil {
+			connection.Close()
+			return stats, fmt.Errorf("failed to open trace file %q: %v", t.traceFile, err)
+		}
+		defer trace.Close()
+		connection = &traceConn{Conn: connection, trace: trace, redact: t.redactSent}
+	}
+	if t.debugLog != nil {
+		connection = &debugConn{Conn: connection, logger: t.debugLog, redact: t.redactSent}
+	}
+	connection = &sessionConn{Conn: connection}
+
+	// Closing the connection on cancellation unblocks any pending reads
+	sessionDone := make(chan struct{})
+	defer close(sessionDone)
+	go func() {
+		select {
+		case <-ctx.Done():
+			connection.Close()
+		case <-sessionDone:
+		}
+	}()
+
+	start := time.Now()
+	defer func() {
+		stats.Duration = time.Since(start)
+	}()
+
+	defer func() {
+		connection.Close()
+		t.infof("Connection closed.")
+		t.logEvent("disconnected", "address", t.address, "bytes_sent", stats.BytesSent, "bytes_received", stats.BytesReceived)
+	}()
+
+	// The reader goroutines stop on sessionCtx, which is cancelled before
+	// the connection is closed so they never act on a dead session
+	sessionCtx, stopSession := context.WithCancel(ctx)
+	defer stopSession()
+
+	// Plain telnet mode bridges the socket as is, with no rlogin handshake
+	var leading []byte
+	if !t.rawTelnet {
+		leading, err = t.sendHandshake(ctx, connection)
+		if err != nil {
+			var rejected *handshakeError
+			if errors.As(err, &rejected) && t.nextHandshake() {
+				return stats, &retryableError{errHandshakeRejected}
+			}
+			return stats, err
+		}
+	}
+
+	// Zmodem transfers are passed to the terminal untouched
+	terminal := outputData
+
+	// Record what is shown on screen: wrapping happens before the translation
+	// below, so the recorder receives the translated output
+	if t.record != "" {
+		recorder, err := t.openRecorder()
+		if err != nil {
+			return stats, fmt.Errorf("failed to create recording %q: %v", t.record, err)
+		}
+		if !t.keepRecorder {
+			defer t.closeRecorder()
+		}
+		outputData = io.MultiWriter(outputData, recorder)
+	}
+
+	// A mirror gets the same output as the terminal, for someone watching
+	// along; it never holds up the session
+	if t.mirror != "" {
+		mirror := newMirrorWriter(t.mirror, t.errorf)
+		defer mirror.Close()
+		outputData = io.MultiWriter(outputData, mirror)
+	}
+
+	// The transcript also receives the translated output, with escape
+	// sequences removed so it reads as plain text
+	if t.logFile != "" {
+		transcript, err := newTranscriptWriter(t.logFile, t.address)
+		if err != nil {
+			return stats, fmt.Errorf("failed to open log file %q: %v", t.logFile, err)
+		}
+		defer transcript.Close()
+		outputData = io.MultiWriter(outputData, newANSIStripper(transcript))
+	}
+
+	// A kiosk keeps one transcript per session, pruning the oldest
+	if t.sessionLogDir != "" {
+		transcript, err := openSessionLog(t.sessionLogDir, t.address, t.sessionLogKeep)
+		if err != nil {
+			return stats, fmt.Errorf("failed to open session log in %q: %v", t.sessionLogDir, err)
+		}
+		defer transcript.Close()
+		outputData = io.MultiWriter(outputData, newANSIStripper(transcript))
+	}
+
+	// Screen captures render the translated output too
+	if t.captureScreens != "" {
+		cols, rows := t.windowSize()
+		capture, err := newScreenCapture(t.captureScreens, cols, rows)
+		if err != nil {
+			return stats, fmt.Errorf("failed to create screen capture %q: %v", t.captureScreens, err)
+		}
+		defer capture.Close()
+		outputData = io.MultiWriter(outputData, capture)
+	}
+
+	// Wrapping counts the translated characters, so it comes after
+	if t.wrap > 0 {
+		outputData = newLineWrapper(outputData, t.wrap)
+	}
+
+	// Translate the (already IAC-stripped) server payload for the local
+	// terminal; a UTF-8 board's output is only kept in whole characters
+	switch t.encoding {
+	case "cp437":
+		outputData = newCP437Writer(outputData)
+	case "utf8":
+		outputData = newUTF8Writer(outputData)
+	}
+
+	// Colors are rewritten in the server's bytes, ahead of the CP437
+	// translation
+	if t.colorDowngrade != "" {
+		outputData = newColorDowngrader(outputData, t.colorDowngrade)
+	}
+
+	// Escape sequences are plain ASCII, so stripping them before the CP437
+	// translation leaves clean UTF-8 text
+	if t.plain {
+		outputData = newANSIStripper(outputData)
+	}
+
+	// Throttle the raw payload bytes as they would arrive over a modem
+	if t.emulateBaud > 0 {
+		outputData = newThrottledWriter(outputData, t.emulateBaud)
+	}
+
+	// Clear away the banner, or whatever else is on screen, as soon as
+	// the server starts drawing
+	if t.clearOnConnect {
+		outputData = &screenClearer{writer: outputData}
+	}
+
+	// Switch to pure byte passthrough, in both directions, for the duration
+	// of a Zmodem download so the translation can't corrupt it
+	var zmodem *zmodemGuard
+	if !t.noZmodemDetect {
+		zmodem = newZmodemGuard(outputData, terminal)
+		outputData = zmodem
+
+		// Or hand transfers to lrzsz, which talks to the server directly
+		if t.zmodemRz != "" || t.zmodemSz != "" {
+			zmodem.helper = &zmodemHelper{
+				rz:     t.zmodemRz,
+				sz:     t.zmodemSz,
+				dir:    t.zmodemDownloadDir,
+				server: connection,
+			}
+			if t.zmodemUpload != "" {
+				zmodem.helper.upload = strings.Split(t.zmodemUpload, ",")
+			}
+			zmodem.errorf = t.errorf
+			defer zmodem.Close()
+		}
+	}
+
+	// Keyboard input can be paced so large pastes don't overrun the BBS,
+	// and pastes are bracketed once the server enables DECSET 2004
+	serverWriter := newPacedWriter(connection, t.pasteChunk, t.pasteDelay)
+	pasteMode := &pasteModeDetector{enabled: t.bracketedPaste}
+
+	requestDataChannel := make(chan inputChunk)
+	doneChannel := make(chan bool)
+	responseDataChannel := make(chan serverChunk)
+	inputErrorChannel := make(chan error, 1) // Channel to report input read failures
+	escapeChannel := make(chan byte)         // Channel for local escape commands
+	closeSignal := make(chan bool)           // Channel to signal server disconnection
+	closing := false                         // Flag to indicate if we're closing
+
+	negotiator := t.newNegotiator(connection)
+	reader := newServerReader(connection, negotiator, t.bufferSize)
+	if leading != nil {
+		reader.Prepend(leading)
+	}
+
+	// Servers that follow rlogin window negotiation rather than NAWS learn
+	// the size now, and again after every resize
+	if err := t.sendRloginWindow(connection); err != nil {
+		return stats, fmt.Errorf("failed to send rlogin window size: %v", err)
+	}
+
+	// -init goes out once, straight after the handshake, unless it should
+	// be repeated for every connection
+	if t.initText != "" && (!t.initSent || t.initOnReconnect) {
+		n, err := t.typeOut(connection, []byte(t.initText))
+		stats.BytesSent += int64(n)
+		t.metrics.addSent(n)
+		if err != nil {
+			return stats, fmt.Errorf("failed to send -init keystrokes: %v", err)
+		}
+		t.initSent = true
+	}
+
+	// Run the login script before stdin takes over
+	if len(t.script) > 0 {
+		if err := t.runScript(connection, reader, outputData, &stats); err != nil {
+			if ctx.Err() != nil {
+				return stats, ctx.Err()
+			}
+			return stats, err
+		}
+	}
+
+	// Re-send the window size whenever the local terminal is resized
+	resizeChannel := make(chan os.Signal, 1)
+	notifyResize(resizeChannel)
+	defer signal.Stop(resizeChannel)
+
+	// Start data handling goroutines
+	go t.readInputData(sessionCtx, t.inputFor(inputData), zmodem, requestDataChannel, escapeChannel, doneChannel, inputErrorChannel)
+	go t.readServerData(sessionCtx, reader, responseDataChannel, closeSignal)
+
+	// A -timeout of 0 or less waits indefinitely after the input ends; the
+	// nil channel keeps the select case disabled.
+	var afterEOFResponseTicker *time.Ticker
+	var afterEOFChannel <-chan time.Time
+	if t.responseTimeout > 0 {
+		afterEOFResponseTicker = time.NewTicker(t.responseTimeout)
+		defer afterEOFResponseTicker.Stop()
+		afterEOFChannel = afterEOFResponseTicker.C
+	}
+
+	// The idle timer is restarted by every chunk the server sends; a nil
+	// channel keeps the select case disabled when no idle timeout is set.
+	var idleTimer *time.Timer
+	var idleChannel <-chan time.Time
+	if t.idleTimeout > 0 {
+		idleTimer = time.NewTimer(t.idleTimeout)
+		defer idleTimer.Stop()
+		idleChannel = idleTimer.C
+	}
+
+	// The anti-idle timer is restarted by every chunk the user types, so
+	// the heartbeat only goes out while the user is reading
+	var antiIdleTimer *time.Timer
+	var antiIdleChannel <-chan time.Time
+	if t.antiIdle > 0 {
+		antiIdleTimer = time.NewTimer(t.antiIdle)
+		defer antiIdleTimer.Stop()
+		antiIdleChannel = antiIdleTimer.C
+	}
+
+	// The stall timer only runs while the server looks to be partway
+	// through drawing: the last chunk filled the read buffer, so more was
+	// waiting, or broke off inside an escape sequence. A board that pauses
+	// at a prompt or between screens is left alone.
+	var stallTimer *time.Timer
+	var stallChannel <-chan time.Time
+	stallScan := newANSIStripper(io.Discard)
+	if t.stallTimeout > 0 {
+		stallTimer = time.NewTimer(t.stallTimeout)
+		stallTimer.Stop()
+		defer stallTimer.Stop()
+		stallChannel = stallTimer.C
+	}
+
+	// -fail-on watches the early output, with escape sequences removed,
+	// for the board's login failure message
+	var failText bytes.Buffer
+	var failScan io.Writer
+	var failWindow <-chan time.Time
+	if t.failOn != "" {
+		failScan = newANSIStripper(&failText)
+		failTimer := time.NewTimer(t.failOnWindow)
+		defer failTimer.Stop()
+		failWindow = failTimer.C
+	}
+
+	// -max-duration ends the session outright, after showing the time
+	// remaining -max-duration-warn beforehand
+	var maxDurationChannel, maxDurationWarnChannel <-chan time.Time
+	if t.maxDuration > 0 {
+		maxDurationTimer := time.NewTimer(t.maxDuration)
+		defer maxDurationTimer.Stop()
+		maxDurationChannel = maxDurationTimer.C
+		if t.maxDurationWarn > 0 && t.maxDurationWarn < t.maxDuration {
+			warnTimer := time.NewTimer(t.maxDuration - t.maxDurationWarn)
+			defer warnTimer.Stop()
+			maxDurationWarnChannel = warnTimer.C
+		}
+	}
+
+	// The ~s status line is cleared when statusTimer fires
+	var statusTimer *time.Timer
+	var statusChannel <-chan time.Time
+	showStatus := func() {
+		var remaining time.Duration
+		if t.maxDuration > 0 {
+			remaining = t.maxDuration - time.Since(start)
+		}
+		_, rows := t.windowSize()
+		showStatusLine(os.Stderr, rows, t.address, stats, time.Since(start), remaining)
+		if statusTimer == nil {
+			statusTimer = time.NewTimer(statusClearDelay)
+		} else {
+			resetTimer(statusTimer, statusClearDelay)
+		}
+		statusChannel = statusTimer.C
+	}
+	defer func() {
+		if statusTimer != nil {
+			statusTimer.Stop()
+		}
+	}()
+
+	var afterEOFMode bool
+	var somethingRead bool
+
+	for {
+		select {
+		case chunk := <-requestDataChannel:
+			request := chunk.data
+			if closing {
+				t.infof("Connection closing; stopping writes.")
+				return stats, nil
+			}
+			if zmodem.Helping() {
+				// Typing would corrupt the transfer
+				continue
+			}
+			if err := t.writeRequest(serverWriter, request, pasteMode.enabled && chunk.paste && !zmodem.Active()); err != nil {
+				return stats, fmt.Errorf("error occurred while writing to TCP socket: %v", err)
+			}
+			// A raw terminal leaves echoing to the server, unless the
+			// server declined it with WONT ECHO or -local-echo is set
+			if t.rawTerminal && (t.localEcho || negotiator.LocalEcho()) && !zmodem.Active() {
+				outputData.Write(echoBytes(request))
+			}
+			stats.BytesSent += int64(len(request))
+			t.metrics.addSent(len(request))
+			if antiIdleTimer != nil {
+				resetTimer(antiIdleTimer, t.antiIdle)
+			}
+		case err := <-inputErrorChannel:
+			return stats, fmt.Errorf("error reading input data: %v", err)
+		case command := <-escapeChannel:
+			switch command {
+			case '.':
+				t.infof("Disconnected.")
+				return stats, nil
+			case '?':
+				fmt.Fprintf(os.Stderr, escapeHelp, t.escape[0])
+			case 's':
+				showStatus()
+			}
+		case <-statusChannel:
+			_, rows := t.windowSize()
+			clearStatusLine(os.Stderr, rows)
+			statusChannel = nil
+		case <-doneChannel:
+			afterEOFMode = true
+			closing = true // Set closing flag
+			// The countdown starts from the end of the input, not from
+			// wherever the ticker happened to be
+			if afterEOFResponseTicker != nil {
+				afterEOFResponseTicker.Reset(t.responseTimeout)
+			}
+		case chunk := <-responseDataChannel:
+			response := chunk.payload
+			if !t.bracketedPaste {
+				pasteMode.Scan(response)
+			}
+			if _, err := outputData.Write(response); err != nil {
+				// Nobody is reading any more, e.g. a pipe's reader exited
+				return stats, fmt.Errorf("error writing output: %v", err)
+			}
+			stats.BytesReceived += int64(len(response))
+			t.metrics.addReceived(len(response))
+			if failScan != nil {
+				failScan.Write(response)
+				if bytes.Contains(bytes.ToLower(failText.Bytes()), bytes.ToLower([]byte(t.failOn))) {
+					return stats, fmt.Errorf("%w: the server said %q", errLoginRejected, t.failOn)
+				}
+				// Keep just enough to match text split across reads
+				if keep := len(t.failOn) - 1; failText.Len() > keep {
+					failText.Next(failText.Len() - keep)
+				}
+			}
+			if stallTimer != nil {
+				stallScan.Write(response)
+				if (stallScan.state != ansiText || chunk.full) && !zmodem.Active() {
+					resetTimer(stallTimer, t.stallTimeout)
+				} else if !stallTimer.Stop() {
+					select {
+					case <-stallTimer.C:
+					default:
+					}
+				}
+			}
+			releasePayload(response)
+			somethingRead = true
+			if idleTimer != nil {
+				resetTimer(idleTimer, t.idleTimeout)
+			}
+			if afterEOFMode && afterEOFResponseTicker != nil {
+				afterEOFResponseTicker.Reset(t.responseTimeout)
+			}
+		case <-afterEOFChannel:
+			if afterEOFMode && somethingRead {
+				// The server has had its say and gone quiet for -timeout
+				t.infof("Connection closing; no output for %v after the input ended.", t.responseTimeout)
+				return stats, nil
+			}
+			if afterEOFMode {
+				switch action, data, _ := strings.Cut(t.timeoutAction, " "); action {
+				case "send":
+					// Try to wake the board, then give it another -timeout
+					if _, err := serverWriter.WriteUnsplit([]byte(data)); err != nil {
+						return stats, fmt.Errorf("error occurred while writing to TCP socket: %v", err)
+					}
+					stats.BytesSent += int64(len(data))
+					t.metrics.addSent(len(data))
+					afterEOFResponseTicker.Reset(t.responseTimeout)
+				case "ignore":
+					t.infof("No response within %v of the input ending; still waiting.", t.responseTimeout)
+					afterEOFChannel = nil
+				default:
+					return stats, errResponseTimeout
+				}
+			}
+		case <-antiIdleChannel:
+			if _, err := serverWriter.WriteUnsplit([]byte(t.antiIdleBytes)); err != nil {
+				return stats, fmt.Errorf("error occurred while writing to TCP socket: %v", err)
+			}
+			antiIdleTimer.Reset(t.antiIdle)
+		case <-idleChannel:
+			return stats, errIdleTimeout
+		case <-stallChannel:
+			t.errorf("Server went quiet for %v partway through a screen.", t.stallTimeout)
+			return stats, &retryableError{errStalled}
+		case <-failWindow:
+			failScan = nil
+			failWindow = nil
+		case <-maxDurationWarnChannel:
+			if t.rawTerminal {
+				showStatus()
+			} else {
+				t.infof("%v left before -max-duration disconnects.", t.maxDurationWarn)
+			}
+		case <-maxDurationChannel:
+			return stats, errMaxDuration
+		case <-resizeChannel:
+			negotiator.SendWindowSize()
+			if err := t.sendRloginWindow(connection); err != nil {
+				return stats, fmt.Errorf("error occurred while writing to TCP socket: %v", err)
+			}
+		case <-closeSignal:
+			if ctx.Err() != nil {
+				return stats, ctx.Err()
+			}
+			t.infof("Server disconnected.")
+			if !t.rawTelnet && t.nextHandshake() {
+				return stats, &retryableError{errHandshakeRejected}
+			}
+			return stats, &retryableError{ErrServerClosed}
+		case <-ctx.Done():
+			return stats, ctx.Err()
+		}
+	}
+}
+
+// sendHandshake sends the rlogin handshake and waits for the server to
+// acknowledge it. If the server skipped the acknowledgement, the byte it
+// sent instead is returned so it can be passed on as session data.
+func (t *TelnetClient) sendHandshake(ctx context.Context, connection net.Conn) ([]byte, error) {
+	handshake, err := buildHandshake(t.options, t.handshakes[t.handshakeIndex])
+	if err != nil {
+		return nil, err
+	}
+	t.handshakeSent = time.Now()
+
+	// Write handshake to the connection
+	if _, err := connection.Write([]byte(handshake)); err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, fmt.Errorf("failed to send rlogin handshake: %v", err)
+	}
+
+	// The server acknowledges the handshake with a single NUL byte. Nothing
+	// at all within the connect timeout means we aren't talking to rlogin,
+	// unless -skip-ack allows for servers that never send it.
+	if t.skipAck {
+		connection.SetReadDeadline(time.Now().Add(ackWindow))
+	} else if t.connectTimeout > 0 {
+		connection.SetReadDeadline(time.Now().Add(t.connectTimeout))
+	}
+
+	nullbuf := make([]byte, 1)
+
+	if _, err := connection.Read(nullbuf); err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			if t.skipAck {
+				connection.SetReadDeadline(time.Time{})
+				return nil, nil
+			}
+			return nil, errNoHandshakeResponse
+		}
+		return nil, &handshakeError{fmt.Errorf("did not receive null byte: %w", err)}
+	}
+
+	connection.SetReadDeadline(time.Time{})
+
+	// Strict mode insists on the acknowledgement; otherwise a server that
+	// skips it has just sent its first byte of session data
+	if nullbuf[0] != '\x00' {
+		if t.rloginStrict {
+			return nil, &handshakeError{fmt.Errorf("did not receive null byte, got 0x%02x", nullbuf[0])}
+		}
+		return nullbuf, nil
+	}
+	return nil, nil
+}
+
+// sendRloginWindow reports the terminal size with an rlogin window-change
+// message when -rlogin-winch is set. Proper rlogin servers ask for it with
+// TCP urgent data, which Go can't read, so it is sent unprompted instead.
+func (t *TelnetClient) sendRloginWindow(connection net.Conn) error {
+	if !t.rloginWinch || t.rawTelnet {
+		return nil
+	}
+	cols, rows := t.windowSize()
+	_, err := connection.Write(rloginWindowMessage(cols, rows))
+	return err
+}
+
+// nextHandshake switches to the next handshake template if the server hung
+// up within -reject-window of the handshake, taken as a sign it didn't
+// accept that format. It reports whether there was another template to try.
+func (t *TelnetClient) nextHandshake() bool {
+	if t.handshakeIndex+1 >= len(t.handshakes) || time.Since(t.handshakeSent) > t.rejectWindow {
+		return false
+	}
+	t.handshakeIndex++
+	t.infof("Handshake rejected; trying alternate handshake %d of %d.", t.handshakeIndex, len(t.handshakes)-1)
+	return true
+}
+
+// inputChunk is a chunk of keyboard input, ready to send.
+type inputChunk struct {
+	data  []byte
+	paste bool // typed as a paste rather than a keystroke; see isPaste
+}
+
+// readInputData forwards local input from the client's input pump to toSend
+// until it ends: EOF is signalled on doneChannel and any other read error
+// is reported on errorChannel. It returns as soon as ctx is done, so nothing
+// is left running once the session ends.
+func (t *TelnetClient) readInputData(ctx context.Context, input *inputPump, zmodem *zmodemGuard, toSend chan<- inputChunk, escapeChannel chan<- byte, doneChannel chan<- bool, errorChannel chan<- error) {
+	var encoder *cp437Encoder
+	if t.encoding == "cp437" {
+		encoder = &cp437Encoder{}
+	}
+
+	var escapes *escapeFilter
+	if t.escape != "" {
+		escapes = newEscapeFilter(t.escape[0])
+	}
+
+	newlines := newNewlineTranslator(t.crlf)
+
+	for {
+		var data []byte
+		var ok bool
+		select {
+		case data, ok = <-input.chunks:
+		case <-ctx.Done():
+			return
+		}
+		if !ok {
+			if input.err == io.EOF {
+				select {
+				case doneChannel <- true:
+				case <-ctx.Done():
+				}
+				return
+			}
+			select {
+			case errorChannel <- input.err:
+			case <-ctx.Done():
+			}
+			return
+		}
+
+		if zmodem.Active() {
+			// The terminal is answering a transfer; send its bytes as is
+			select {
+			case toSend <- inputChunk{data: data}:
+			case <-ctx.Done():
+				return
+			}
+			continue
+		}
+
+		for len(data) > 0 {
+			send, command, rest := data, byte(0), []byte(nil)
+			if escapes != nil {
+				send, command, rest = escapes.Next(data)
+			}
+			// Whether it was pasted is judged on what was typed, before
+			// line endings are expanded
+			paste := isPaste(send)
+			send = newlines.Translate(send)
+			if encoder != nil {
+				// Map typed UTF-8 characters back to the BBS's CP437
+				send = encoder.Encode(send)
+			}
+			if len(send) > 0 {
+				select {
+				case toSend <- inputChunk{data: send, paste: paste}:
+				case <-ctx.Done():
+					return
+				}
+			}
+			if command != 0 {
+				select {
+				case escapeChannel <- command:
+				case <-ctx.Done():
+					return
+				}
+			}
+			data = rest
+		}
+	}
+}
+
+// writeRequest sends a chunk of keyboard input to the server, wrapped in
+// bracketed paste markers if bracket is set.
+func (t *TelnetClient) writeRequest(w *pacedWriter, request []byte, bracket bool) error {
+	if bracket {
+		if _, err := w.WriteUnsplit(pasteStart); err != nil {
+			return err
+		}
+	}
+	if _, err := w.Write(request); err != nil {
+		return err
+	}
+	if bracket {
+		if _, err := w.WriteUnsplit(pasteEnd); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// echoBytes returns how typed input looks echoed to the terminal: line
+// endings start a new line, backspace rubs out a character, and escape
+// sequences such as arrow keys aren't echoed at all.
+func echoBytes(p []byte) []byte {
+	if len(p) > 0 && p[0] == 0x1b {
+		return nil
+	}
+
+	echo := make([]byte, 0, len(p))
+	for i, b := range p {
+		switch {
+		case b == '\r':
+			echo = append(echo, '\r', '\n')
+		case b == '\n':
+			if i == 0 || p[i-1] != '\r' {
+				echo = append(echo, '\r', '\n')
+			}
+		case b == '\b' || b == 0x7f:
+			echo = append(echo, '\b', ' ', '\b')
+		case b >= 0x20 || b == '\t':
+			echo = append(echo, b)
+		}
+	}
+	return echo
+}
+
+// errSessionClosed is returned by writes attempted after the session's
+// connection has been closed.
+var errSessionClosed = errors.New("write after connection closed")
+
+// sessionConn guards a connection so that nothing, including telnet
+// negotiation replies from the reader goroutine, writes to it once it has
+// been closed.
+type sessionConn struct {
+	net.Conn
+	mu     sync.Mutex
+	closed bool
+}
+
+func (c *sessionConn) Write(p []byte) (int, error) {
+	return c.WriteMasked(p, p)
+}
+
+func (c *sessionConn) WriteMasked(p, shown []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return 0, errSessionClosed
+	}
+	return writeMasked(c.Conn, p, shown)
+}
+
+// Close closes the connection, which also fails any write in progress, and
+// then blocks further writes.
+func (c *sessionConn) Close() error {
+	err := c.Conn.Close()
+	c.mu.Lock()
+	c.closed = true
+	c.mu.Unlock()
+	return err
+}
+
+// resetTimer restarts timer for d, draining a pending fire first.
+func resetTimer(timer *time.Timer, d time.Duration) {
+	if !timer.Stop() {
+		select {
+		case <-timer.C:
+		default:
+		}
+	}
+	timer.Reset(d)
+}
+
+// serverTarget is one host from -host, ready to dial.
+type serverTarget struct {
+	network     string
+	address     string
+	destination *net.TCPAddr // nil when dialing through a proxy
+	serverName  string       // TLS server name unless -tls-servername is set
+}
+
+// String describes the target for log messages: the host as given and the
+// address it resolved to, such as "bbs.example.com (203.0.113.5:2513)".
+func (s serverTarget) String() string {
+	if s.destination == nil || s.serverName == s.destination.IP.String() {
+		return s.address
+	}
+	return fmt.Sprintf("%s (%s)", s.serverName, s.destination)
+}
+
+// dial connects to the first target that accepts the connection, each
+// attempt bounded by the connect timeout, and makes it the current target.
+func (t *TelnetClient) dial() (net.Conn, error) {
+	var firstErr error
+	for _, target := range t.targets {
+		t.infof("Connecting to %s.", target)
+		connection, err := t.dialTarget(target)
+		if err != nil {
+			if len(t.targets) > 1 {
+				t.infof("Could not connect to %s: %v", target, err)
+			}
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+
+		if len(t.targets) > 1 {
+			t.infof("Connected to %s.", target)
+		}
+		t.network, t.address, t.destination = target.network, target.address, target.destination
+		return connection, nil
+	}
+	return nil, firstErr
+}
+
+// dialTarget opens the connection to one server and wraps it in TLS if requested.
+func (t *TelnetClient) dialTarget(target serverTarget) (net.Conn, error) {
+	connection, err := t.dialTransport(target)
+	if err != nil {
+		return nil, err
+	}
+
+	// Keepalive probes make a silently vanished peer surface as a read error
+	if tcpConn, ok := connection.(*net.TCPConn); ok {
+		if t.keepAlive > 0 {
+			tcpConn.SetKeepAlive(true)
+			tcpConn.SetKeepAlivePeriod(t.keepAlive)
+		} else {
+			tcpConn.SetKeepAlive(false)
+		}
+	}
+
+	if t.tlsConfig == nil {
+		return connection, nil
+	}
+
+	tlsConfig := t.tlsConfig.Clone()
+	if tlsConfig.ServerName == "" {
+		tlsConfig.ServerName = target.serverName
+	}
+	tlsConn := tls.Client(connection, tlsConfig)
+	if t.connectTimeout > 0 {
+		tlsConn.SetDeadline(time.Now().Add(t.connectTimeout))
+	}
+	if err := tlsConn.Handshake(); err != nil {
+		connection.Close()
+		return nil, fmt.Errorf("TLS handshake failed: %v", err)
+	}
+	tlsConn.SetDeadline(time.Time{})
+	return tlsConn, nil
+}
+
+// dialTransport opens the TCP connection, directly or through the SOCKS5
+// proxy, or connects to a Unix socket.
+func (t *TelnetClient) dialTransport(target serverTarget) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: t.connectTimeout}
+	if target.network == "unix" {
+		return dialer.Dial(target.network, target.address)
+	}
+	if t.localAddr != nil {
+		// With a proxy this is the address the proxy sees
+		dialer.LocalAddr = t.localAddr
+	}
+	if t.proxy == "" {
+		return dialer.Dial(target.network, target.destination.String())
+	}
+
+	proxyURL, err := url.Parse(t.proxy)
+	if err != nil {
+		return nil, err
+	}
+	proxyDialer, err := proxy.FromURL(proxyURL, dialer)
+	if err != nil {
+		return nil, err
+	}
+	return proxyDialer.Dial(target.network, target.address)
+}
+
+// serverChunk is the payload from one read of the server connection.
+type serverChunk struct {
+	payload []byte
+	full    bool // the read filled the buffer, so more was waiting
+}
+
+func (t *TelnetClient) readServerData(ctx context.Context, reader *serverReader, received chan<- serverChunk, closeSignal chan<- bool) {
+	for {
+		// Telnet negotiation is stripped so only the payload reaches the output
+		payload, full, err := reader.ReadPayload()
+		if len(payload) > 0 {
+			select {
+			case received <- serverChunk{payload: payload, full: full}:
+			case <-ctx.Done():
+				return
+			}
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				// Connection was closed because the session was cancelled
+				return
+			}
+			if err == io.EOF {
+				t.infof("Server closed the connection.")
+			} else {
+				t.errorf("Error occurred while reading from server: %v", err)
+			}
+			select {
+			case closeSignal <- true:
+			case <-ctx.Done():
+			}
+			close(received)
+			return
+		}
+
+		if full {
+			time.Sleep(sleepBufferFullMilli * time.Millisecond)
+		}
+	}
+}
+
+// newNegotiator returns a telnet negotiator that answers on connection.
+func (t *TelnetClient) newNegotiator(connection net.Conn) *telnetNegotiator {
+	return &telnetNegotiator{
+		writer:     connection,
+		termType:   t.termType,
+		windowSize: t.windowSize,
+		noCompress: t.noCompress,
+		trace:      t.debugLog,
+	}
+}
+
+// windowSize returns the terminal dimensions to report to the server,
+// preferring the -cols/-rows overrides over the detected size.
+func (t *TelnetClient) windowSize() (int, int) {
+	cols, rows := defaultCols, defaultRows
+	if term.IsTerminal(int(os.Stdout.Fd())) {
+		if w, h, err := term.GetSize(int(os.Stdout.Fd())); err == nil {
+			cols, rows = w, h
+		}
+	}
+	if t.cols > 0 {
+		cols = t.cols
+	}
+	if t.rows > 0 {
+		rows = t.rows
+	}
+	return cols, rows
+}
+
+// SplitHosts returns the hosts in a comma-separated -host value.
+func SplitHosts(hosts string) []string {
+	var list []string
+	for _, host := range strings.Split(hosts, ",") {
+		if host = strings.TrimSpace(host); host != "" {
+			list = append(list, host)
+		}
+	}
+	return list
+}
+
+// unixHostPrefix marks a -host entry as the path of a Unix domain socket.
+const unixHostPrefix = "unix:"
+
+// IsUnixHost reports whether host names a Unix socket, e.g. unix:/run/bbs.sock.
+func IsUnixHost(host string) bool {
+	return strings.HasPrefix(host, unixHostPrefix)
+}
+
+// NeedsPort reports whether any of the comma-separated hosts is reached
+// over TCP without a port of its own, and so needs -port.
+func NeedsPort(hosts string) bool {
+	for _, host := range SplitHosts(hosts) {
+		if _, _, err := net.SplitHostPort(host); !IsUnixHost(host) && err != nil {
+			return true
+		}
+	}
+	return len(SplitHosts(hosts)) == 0
+}
+
+// hostLiteral returns the host with any port and IPv6 brackets removed.
+func hostLiteral(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		return h
+	}
+	return strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+}
+
+// resolveNetwork picks the network to dial, switching to tcp6 when the host
+// is an explicit IPv6 literal and no address family was forced.
+func resolveNetwork(network, host string) string {
+	if network == "" {
+		network = "tcp"
+	}
+	if network == "tcp" {
+		if ip := net.ParseIP(hostLiteral(host)); ip != nil && ip.To4() == nil {
+			network = "tcp6"
+		}
+	}
+	return network
+}
+
+// createTCPAddr builds a TCP address string, bracketing IPv6 literals. A
+// port given with the host, as in bbs.example.com:2513, wins over port.
+func createTCPAddr(host string, port uint64) string {
+	if h, p, err := net.SplitHostPort(host); err == nil {
+		return net.JoinHostPort(h, p)
+	}
+	return net.JoinHostPort(hostLiteral(host), strconv.FormatUint(port, 10))
+}
+
+// resolveTCPAddr resolves a TCP address string.
+func resolveTCPAddr(network, addr string) (*net.TCPAddr, error) {
+	resolved, err := net.ResolveTCPAddr(network, addr)
+	if err != nil {
+		return nil, &resolveError{address: addr, err: err}
+	}
+	return resolved, nil
+}
+
+// resolveBindAddr resolves a -bind address, an IP or host name with an
+// optional port, and checks that it belongs to this machine.
+func resolveBindAddr(bind string) (*net.TCPAddr, error) {
+	host, port := bind, "0"
+	if h, p, err := net.SplitHostPort(bind); err == nil {
+		host, port = h, p
+	}
+	resolved, err := net.ResolveTCPAddr("tcp", net.JoinHostPort(hostLiteral(host), port))
+	if err != nil {
+		return nil, fmt.Errorf("error occurred while resolving bind address \"%v\": %w", bind, err)
+	}
+	if resolved.IP == nil || resolved.IP.IsUnspecified() {
+		return resolved, nil
+	}
+
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list local addresses for -bind: %v", err)
+	}
+	for _, addr := range addrs {
+		// The whole loopback range can be bound, not just 127.0.0.1
+		ipNet, ok := addr.(*net.IPNet)
+		if ok && (ipNet.IP.Equal(resolved.IP) || resolved.IP.IsLoopback() && ipNet.Contains(resolved.IP)) {
+			return resolved, nil
+		}
+	}
+	return nil, fmt.Errorf("bind address %v is not an address of this machine", resolved.IP)
+}
+
+// resolveTargets returns the targets to dial for the comma-separated hosts,
+// which are tried in order as fallbacks. Hosts that can't be resolved are
+// skipped unless none of them resolve.
+func (t *TelnetClient) resolveTargets(ctx context.Context, hosts string, port uint64, network string, dnsRetries int) ([]serverTarget, error) {
+	var targets []serverTarget
+	var skipped []error
+	for _, host := range SplitHosts(hosts) {
+		if IsUnixHost(host) {
+			targets = append(targets, serverTarget{
+				network: "unix",
+				address: strings.TrimPrefix(host, unixHostPrefix),
+			})
+			continue
+		}
+
+		target := serverTarget{
+			network:    resolveNetwork(network, host),
+			address:    createTCPAddr(host, port),
+			serverName: hostLiteral(host),
+		}
+
+		// When going through a proxy the proxy resolves the host, so we don't
+		// leak DNS lookups or fail on networks that can't resolve it locally.
+		if t.proxy == "" {
+			resolved, err := t.resolveTCPAddrRetrying(ctx, target.network, target.address, dnsRetries)
+			if err != nil {
+				skipped = append(skipped, err)
+				continue
+			}
+			target.destination = resolved
+		}
+		targets = append(targets, target)
+	}
+	if len(targets) == 0 {
+		if len(skipped) > 0 {
+			return nil, skipped[0]
+		}
+		return nil, errors.New("no host to connect to")
+	}
+
+	for _, err := range skipped {
+		t.infof("Skipping host: %v", err)
+	}
+	return targets, nil
+}
+
+// reresolveTargets looks the resolved targets up again before a reconnect,
+// so a board that moved to a new address is found there. A target that
+// fails to resolve now keeps the address it had.
+func (t *TelnetClient) reresolveTargets(ctx context.Context) {
+	for i, target := range t.targets {
+		if target.destination == nil {
+			continue
+		}
+		resolved, err := t.resolveTCPAddrRetrying(ctx, target.network, target.address, t.options.DNSRetries())
+		if err != nil {
+			t.infof("Keeping the last address of %s: %v", target.address, err)
+			continue
+		}
+		t.targets[i].destination = resolved
+	}
+}
+
+// resolveTCPAddrRetrying is resolveTCPAddr, retrying up to retries times
+// with a growing delay when the lookup failed for a temporary reason, such
+// as the network still coming up after a laptop wakes. A host that doesn't
+// exist fails straight away.
+func (t *TelnetClient) resolveTCPAddrRetrying(ctx context.Context, network, addr string, retries int) (*net.TCPAddr, error) {
+	delay := dnsRetryDelay
+	for attempt := 1; ; attempt++ {
+		resolved, err := resolveTCPAddr(network, addr)
+		var dnsErr *net.DNSError
+		if err == nil || attempt > retries || !errors.As(err, &dnsErr) || dnsErr.IsNotFound ||
+			!dnsErr.IsTemporary && !dnsErr.IsTimeout {
+			return resolved, err
+		}
+
+		t.infof("DNS lookup failed, retrying in %v (attempt %d of %d): %v", delay, attempt, retries, err)
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		}
+		delay *= 2
+		if delay > maxRetryDelay {
+			delay = maxRetryDelay
+		}
+	}
+}
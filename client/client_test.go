@@ -0,0 +1,910 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testTimeout bounds anything a test waits for, so a broken session fails
+// the test instead of hanging it.
+const testTimeout = 5 * time.Second
+
+// mockServer is a fake GoldMine server on a local TCP port. Each accepted
+// connection is handed to the test, which plays the server's part with the
+// helpers below.
+type mockServer struct {
+	t        *testing.T
+	listener net.Listener
+	conns    chan net.Conn
+}
+
+// newMockServer listens on address, such as "127.0.0.1:0", until the test ends.
+func newMockServer(t *testing.T, address string) *mockServer {
+	t.Helper()
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		t.Fatalf("failed to listen on %s: %v", address, err)
+	}
+	s := &mockServer{t: t, listener: listener, conns: make(chan net.Conn, 4)}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				close(s.conns)
+				return
+			}
+			s.conns <- conn
+		}
+	}()
+	t.Cleanup(func() { listener.Close() })
+	return s
+}
+
+// port returns the port the server listens on.
+func (s *mockServer) port() uint64 {
+	return uint64(s.listener.Addr().(*net.TCPAddr).Port)
+}
+
+// accept waits for the client to connect.
+func (s *mockServer) accept() net.Conn {
+	s.t.Helper()
+	select {
+	case conn := <-s.conns:
+		s.t.Cleanup(func() { conn.Close() })
+		return conn
+	case <-time.After(testTimeout):
+		s.t.Fatal("client did not connect")
+		return nil
+	}
+}
+
+// readHandshake reads the n bytes of handshake the server expects and
+// acknowledges it with the NUL byte.
+func readHandshake(t *testing.T, conn net.Conn, n int) []byte {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(testTimeout))
+	handshake := make([]byte, n)
+	if got, err := io.ReadFull(conn, handshake); err != nil {
+		t.Fatalf("failed to read handshake, got %q: %v", handshake[:got], err)
+	}
+	conn.SetReadDeadline(time.Time{})
+	if _, err := conn.Write([]byte{0}); err != nil {
+		t.Fatalf("failed to acknowledge handshake: %v", err)
+	}
+	return handshake
+}
+
+// newTestClient returns a client for options that logs nothing.
+func newTestClient(t *testing.T, options Options) *TelnetClient {
+	t.Helper()
+	client, err := NewTelnetClient(options)
+	if err != nil {
+		t.Fatalf("NewTelnetClient: %v", err)
+	}
+	client.SetLogger(log.New(io.Discard, "", 0))
+	return client
+}
+
+// pipeServer makes client dial one end of a net.Pipe and returns the other,
+// for tests that play the server without a listener.
+func pipeServer(t *testing.T, client *TelnetClient) net.Conn {
+	t.Helper()
+	clientEnd, serverEnd := net.Pipe()
+	t.Cleanup(func() { serverEnd.Close() })
+	client.dialer = func() (net.Conn, error) { return clientEnd, nil }
+	return serverEnd
+}
+
+// openInput returns input that stays open, as a terminal does, until the
+// returned function is called to end it.
+func openInput(t *testing.T) (io.Reader, func()) {
+	reader, writer := io.Pipe()
+	var once sync.Once
+	end := func() { once.Do(func() { writer.Close() }) }
+	t.Cleanup(end)
+	return reader, end
+}
+
+// syncBuffer is a bytes.Buffer that the session can write to while the
+// test reads it.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// waitFor waits until output contains want.
+func waitFor(t *testing.T, output *syncBuffer, want string) {
+	t.Helper()
+	deadline := time.Now().Add(testTimeout)
+	for !strings.Contains(output.String(), want) {
+		if time.Now().After(deadline) {
+			t.Fatalf("output %q never contained %q", output.String(), want)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// sessionResult is how a session run by startSession ended.
+type sessionResult struct {
+	stats SessionStats
+	err   error
+}
+
+// startSession runs ProcessData in the background.
+func startSession(client *TelnetClient, input io.Reader, output io.Writer) <-chan sessionResult {
+	done := make(chan sessionResult, 1)
+	go func() {
+		stats, err := client.ProcessDataContext(context.Background(), input, output)
+		done <- sessionResult{stats, err}
+	}()
+	return done
+}
+
+// waitSession waits for a session started by startSession to end.
+func waitSession(t *testing.T, done <-chan sessionResult) sessionResult {
+	t.Helper()
+	select {
+	case result := <-done:
+		return result
+	case <-time.After(testTimeout):
+		t.Fatal("session did not end")
+		return sessionResult{}
+	}
+}
+
+func TestBuildHandshake(t *testing.T) {
+	tests := []struct {
+		name     string
+		tag      string
+		opts     []Option
+		template string
+		want     string
+	}{
+		{
+			name: "default",
+			opts: []Option{WithLocalName("me")},
+			want: "\x00me\x00sysop\x00\x00",
+		},
+		{
+			name: "tag",
+			tag:  "GM",
+			opts: []Option{WithLocalName("me")},
+			want: "\x00me\x00[GM]sysop\x00\x00",
+		},
+		{
+			name: "xtrn",
+			opts: []Option{WithLocalName("me"), WithXtrn("LORD")},
+			want: "\x00me\x00sysop\x00xtrn=LORD\x00",
+		},
+		{
+			name: "tag and xtrn",
+			tag:  "GM",
+			opts: []Option{WithLocalName("me"), WithXtrn("LORD")},
+			want: "\x00me\x00[GM]sysop\x00xtrn=LORD\x00",
+		},
+		{
+			name: "password replaces localname",
+			opts: []Option{WithLocalName("me"), WithPassword("hunter2")},
+			want: "\x00hunter2\x00sysop\x00\x00",
+		},
+		{
+			name:     "template",
+			tag:      "GM",
+			opts:     []Option{WithLocalName("me"), WithXtrn("LORD")},
+			template: "{{null}}{{.LocalName}}{{null}}{{.RemoteName}}{{null}}{{.Tag}}/{{.Xtrn}}{{null}}",
+			want:     "\x00me\x00sysop\x00GM/LORD\x00",
+		},
+		{
+			name:     "template secret",
+			opts:     []Option{WithLocalName("me"), WithSecret("s3cret")},
+			template: "{{null}}{{.LocalName}}{{null}}{{.RemoteName}}:{{secret}}:{{.Secret}}{{null}}{{null}}",
+			want:     "\x00me\x00sysop:s3cret:s3cret\x00\x00",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			options := NewOptions("127.0.0.1", 2513, "sysop", tt.tag, tt.opts...)
+			got, err := buildHandshake(options, tt.template)
+			if err != nil {
+				t.Fatalf("buildHandshake: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("buildHandshake = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSessionHandshake(t *testing.T) {
+	tests := []struct {
+		name   string
+		listen string
+		host   string
+		tag    string
+		opts   []Option
+		want   string
+	}{
+		{
+			name:   "default",
+			listen: "127.0.0.1:0",
+			host:   "127.0.0.1",
+			opts:   []Option{WithLocalName("me")},
+			want:   "\x00me\x00sysop\x00\x00",
+		},
+		{
+			name:   "xtrn and localname",
+			listen: "127.0.0.1:0",
+			host:   "127.0.0.1",
+			tag:    "GM",
+			opts:   []Option{WithLocalName("caller"), WithXtrn("LORD")},
+			want:   "\x00caller\x00[GM]sysop\x00xtrn=LORD\x00",
+		},
+		{
+			name:   "IPv6 host",
+			listen: "[::1]:0",
+			host:   "::1",
+			opts:   []Option{WithLocalName("me")},
+			want:   "\x00me\x00sysop\x00\x00",
+		},
+		{
+			name:   "bracketed IPv6 host",
+			listen: "[::1]:0",
+			host:   "[::1]",
+			opts:   []Option{WithLocalName("me")},
+			want:   "\x00me\x00sysop\x00\x00",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if strings.HasPrefix(tt.listen, "[") {
+				probe, err := net.Listen("tcp", tt.listen)
+				if err != nil {
+					t.Skipf("IPv6 loopback unavailable: %v", err)
+				}
+				probe.Close()
+			}
+			server := newMockServer(t, tt.listen)
+			opts := append([]Option{WithQuiet()}, tt.opts...)
+			client := newTestClient(t, NewOptions(tt.host, server.port(), "sysop", tt.tag, opts...))
+
+			input, endInput := openInput(t)
+			output := &syncBuffer{}
+			done := startSession(client, input, output)
+
+			conn := server.accept()
+			if got := readHandshake(t, conn, len(tt.want)); string(got) != tt.want {
+				t.Errorf("server received handshake %q, want %q", got, tt.want)
+			}
+			conn.Write([]byte("Welcome to the board\r\n"))
+			waitFor(t, output, "Welcome to the board\r\n")
+
+			conn.Close()
+			result := waitSession(t, done)
+			if !errors.Is(result.err, ErrServerClosed) {
+				t.Errorf("session ended with %v, want %v", result.err, ErrServerClosed)
+			}
+			if result.stats.BytesReceived != int64(len("Welcome to the board\r\n")) {
+				t.Errorf("BytesReceived = %d, want %d", result.stats.BytesReceived, len("Welcome to the board\r\n"))
+			}
+			endInput()
+		})
+	}
+}
+
+func TestSessionSendsInput(t *testing.T) {
+	server := newMockServer(t, "127.0.0.1:0")
+	client := newTestClient(t, NewOptions("127.0.0.1", server.port(), "sysop", "", WithLocalName("me"), WithQuiet()))
+
+	input, typed := io.Pipe()
+	t.Cleanup(func() { typed.Close() })
+	done := startSession(client, input, io.Discard)
+
+	conn := server.accept()
+	readHandshake(t, conn, len("\x00me\x00sysop\x00\x00"))
+	typed.Write([]byte("hello\n"))
+
+	// -crlf auto sends Enter as CR
+	conn.SetReadDeadline(time.Now().Add(testTimeout))
+	got := make([]byte, len("hello\r"))
+	if _, err := io.ReadFull(conn, got); err != nil {
+		t.Fatalf("failed to read input: %v", err)
+	}
+	if string(got) != "hello\r" {
+		t.Errorf("server received %q, want %q", got, "hello\r")
+	}
+
+	conn.Close()
+	waitSession(t, done)
+}
+
+// testHandshake is the handshake newPipeClient's options produce.
+const testHandshake = "\x00me\x00sysop\x00\x00"
+
+// newPipeClient returns a client that talks to the returned net.Pipe end,
+// giving up -timeout after the input ends.
+func newPipeClient(t *testing.T, timeout time.Duration, timeoutAction string) (*TelnetClient, net.Conn) {
+	t.Helper()
+	client := newTestClient(t, NewOptions("127.0.0.1", 513, "sysop", "", WithLocalName("me"), WithTimeout(timeout), WithQuiet()))
+	client.timeoutAction = timeoutAction
+	return client, pipeServer(t, client)
+}
+
+func TestAfterEOFTimeout(t *testing.T) {
+	const timeout = 100 * time.Millisecond
+
+	tests := []struct {
+		name   string
+		action string
+		// server plays the server's part once the handshake is acknowledged;
+		// the input has already ended
+		server     func(t *testing.T, conn net.Conn)
+		want       error
+		wantExit   int
+		wantOutput string
+	}{
+		{
+			name:     "silent server times out",
+			action:   "exit",
+			server:   func(t *testing.T, conn net.Conn) {},
+			want:     errResponseTimeout,
+			wantExit: ExitTimeout,
+		},
+		{
+			name:   "answer after input ends",
+			action: "exit",
+			server: func(t *testing.T, conn net.Conn) {
+				time.Sleep(timeout / 2)
+				conn.Write([]byte("Goodbye\r\n"))
+			},
+			want:       nil,
+			wantExit:   ExitOK,
+			wantOutput: "Goodbye\r\n",
+		},
+		{
+			name:   "server keeps writing past the timeout",
+			action: "exit",
+			server: func(t *testing.T, conn net.Conn) {
+				for i := 0; i < 8; i++ {
+					time.Sleep(timeout / 2)
+					fmt.Fprintf(conn, "line %d\r\n", i)
+				}
+			},
+			want:       nil,
+			wantExit:   ExitOK,
+			wantOutput: "line 0\r\nline 1\r\nline 2\r\nline 3\r\nline 4\r\nline 5\r\nline 6\r\nline 7\r\n",
+		},
+		{
+			name:   "send wakes the board",
+			action: "send \r",
+			server: func(t *testing.T, conn net.Conn) {
+				got := make([]byte, 1)
+				conn.SetReadDeadline(time.Now().Add(testTimeout))
+				if _, err := io.ReadFull(conn, got); err != nil || string(got) != "\r" {
+					t.Errorf("server received %q, %v; want the -timeout-action bytes", got, err)
+				}
+				conn.Close()
+			},
+			want:     ErrServerClosed,
+			wantExit: ExitFailure,
+		},
+		{
+			name:   "ignore keeps waiting",
+			action: "ignore",
+			server: func(t *testing.T, conn net.Conn) {
+				time.Sleep(3 * timeout)
+				conn.Close()
+			},
+			want:     ErrServerClosed,
+			wantExit: ExitFailure,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, conn := newPipeClient(t, timeout, tt.action)
+			start := time.Now()
+			output := &syncBuffer{}
+			done := startSession(client, strings.NewReader(""), output)
+
+			readHandshake(t, conn, len(testHandshake))
+			tt.server(t, conn)
+			result := waitSession(t, done)
+
+			if !errors.Is(result.err, tt.want) {
+				t.Errorf("session ended with %v, want %v", result.err, tt.want)
+			}
+			if code := ExitCode(result.err); code != tt.wantExit {
+				t.Errorf("ExitCode(%v) = %d, want %d", result.err, code, tt.wantExit)
+			}
+			if tt.want == errResponseTimeout && time.Since(start) < timeout {
+				t.Errorf("session timed out after %v, before -timeout %v", time.Since(start), timeout)
+			}
+			if tt.wantOutput != "" && output.String() != tt.wantOutput {
+				t.Errorf("output = %q, want %q", output.String(), tt.wantOutput)
+			}
+		})
+	}
+}
+
+// TestTimeoutActionSendReply checks that the board's answer to a
+// -timeout-action send is shown in full when it arrives over several
+// writes, rather than the session ending at the first of them.
+func TestTimeoutActionSendReply(t *testing.T) {
+	const timeout = 100 * time.Millisecond
+	reply := []string{"Still there?\r\n", "Press a key\r\n", "Goodbye\r\n"}
+
+	client, conn := newPipeClient(t, timeout, "send \r")
+	output := &syncBuffer{}
+	done := startSession(client, strings.NewReader(""), output)
+	readHandshake(t, conn, len(testHandshake))
+
+	got := make([]byte, 1)
+	conn.SetReadDeadline(time.Now().Add(testTimeout))
+	if _, err := io.ReadFull(conn, got); err != nil || string(got) != "\r" {
+		t.Fatalf("server received %q, %v; want the -timeout-action bytes", got, err)
+	}
+	for _, line := range reply {
+		conn.Write([]byte(line))
+		time.Sleep(timeout / 4)
+	}
+
+	result := waitSession(t, done)
+	if result.err != nil {
+		t.Errorf("session ended with %v, want a clean end", result.err)
+	}
+	if want := strings.Join(reply, ""); output.String() != want {
+		t.Errorf("output = %q, want %q", output.String(), want)
+	}
+}
+
+// TestAfterEOFTimeoutAfterOutput checks that a server that has already
+// answered is given a full -timeout from the end of the input, not from
+// its last output, and that the session then ends cleanly.
+func TestAfterEOFTimeoutAfterOutput(t *testing.T) {
+	const timeout = 100 * time.Millisecond
+
+	client, conn := newPipeClient(t, timeout, "exit")
+	input, endInput := openInput(t)
+	output := &syncBuffer{}
+	done := startSession(client, input, output)
+
+	readHandshake(t, conn, len(testHandshake))
+	conn.Write([]byte("Welcome\r\n"))
+	waitFor(t, output, "Welcome")
+	time.Sleep(2 * timeout)
+	ended := time.Now()
+	endInput()
+
+	result := waitSession(t, done)
+	if result.err != nil {
+		t.Errorf("session ended with %v, want a clean end", result.err)
+	}
+	if elapsed := time.Since(ended); elapsed < timeout {
+		t.Errorf("session ended %v after the input, before -timeout %v", elapsed, timeout)
+	}
+}
+
+// TestNoTimeout checks that -timeout 0 waits indefinitely once the input
+// ends, rather than panicking on a zero ticker, and that the session ends
+// only when the server hangs up.
+func TestNoTimeout(t *testing.T) {
+	for _, timeout := range []time.Duration{0, -time.Second} {
+		client, conn := newPipeClient(t, timeout, "exit")
+		done := startSession(client, strings.NewReader(""), io.Discard)
+		readHandshake(t, conn, len(testHandshake))
+
+		select {
+		case result := <-done:
+			t.Fatalf("-timeout %v: session ended with %v before the server hung up", timeout, result.err)
+		case <-time.After(300 * time.Millisecond):
+		}
+
+		conn.Close()
+		result := waitSession(t, done)
+		if !errors.Is(result.err, ErrServerClosed) {
+			t.Errorf("-timeout %v: session ended with %v, want %v", timeout, result.err, ErrServerClosed)
+		}
+	}
+}
+
+// slowWriter is an output that lags behind the session, as a slow
+// terminal or a full pipe does, and keeps what it is given.
+type slowWriter struct {
+	syncBuffer
+	delay time.Duration
+}
+
+func (w *slowWriter) Write(p []byte) (int, error) {
+	time.Sleep(w.delay)
+	return w.syncBuffer.Write(p)
+}
+
+// TestSlowOutputKeepsAllData checks that output which lags behind the
+// server sees every byte in order: payloads must not be reused for the
+// next read before the output has taken them.
+func TestSlowOutputKeepsAllData(t *testing.T) {
+	client, conn := newPipeClient(t, time.Second, "exit")
+	input, _ := openInput(t)
+	output := &slowWriter{delay: time.Millisecond}
+	done := startSession(client, input, output)
+
+	readHandshake(t, conn, len(testHandshake))
+	var sent bytes.Buffer
+	for i := 0; i < 200; i++ {
+		chunk := bytes.Repeat([]byte{byte('A' + i%26)}, 100+i%7*50)
+		sent.Write(chunk)
+		if _, err := conn.Write(chunk); err != nil {
+			t.Fatalf("failed to write chunk %d: %v", i, err)
+		}
+	}
+	conn.Close()
+
+	result := waitSession(t, done)
+	if !errors.Is(result.err, ErrServerClosed) {
+		t.Errorf("session ended with %v, want %v", result.err, ErrServerClosed)
+	}
+	if diff := goldenDiff("output", sent.Bytes(), []byte(output.String())); diff != "" {
+		t.Error(diff)
+	}
+}
+
+// pipeServers makes client dial a fresh net.Pipe for each session, and
+// returns the server ends in the order they are dialed.
+func pipeServers(t *testing.T, client *TelnetClient) <-chan net.Conn {
+	t.Helper()
+	conns := make(chan net.Conn, 8)
+	client.dialer = func() (net.Conn, error) {
+		clientEnd, serverEnd := net.Pipe()
+		t.Cleanup(func() { serverEnd.Close() })
+		conns <- serverEnd
+		return clientEnd, nil
+	}
+	return conns
+}
+
+// nextConn waits for the client to dial the next pipe from pipeServers.
+func nextConn(t *testing.T, conns <-chan net.Conn) net.Conn {
+	t.Helper()
+	select {
+	case conn := <-conns:
+		return conn
+	case <-time.After(testTimeout):
+		t.Fatal("client did not connect")
+		return nil
+	}
+}
+
+// TestBackToBackSessions runs two sessions on one client, sharing its
+// input pump, with typing racing the first server's hang-up. Run it with
+// -race: nothing from the first session may touch its connection after it
+// is closed, and input typed between the sessions goes to the second.
+func TestBackToBackSessions(t *testing.T) {
+	client := newTestClient(t, NewOptions("127.0.0.1", 513, "sysop", "", WithLocalName("me"), WithQuiet()))
+	conns := pipeServers(t, client)
+	input, typed := io.Pipe()
+	t.Cleanup(func() { typed.Close() })
+
+	done := startSession(client, input, io.Discard)
+	conn := nextConn(t, conns)
+	readHandshake(t, conn, len(testHandshake))
+	go typed.Write([]byte("one\n"))
+	conn.Close()
+	waitSession(t, done)
+
+	done = startSession(client, input, io.Discard)
+	conn = nextConn(t, conns)
+	readHandshake(t, conn, len(testHandshake))
+	go typed.Write([]byte("two\n"))
+
+	// "one" either reached the first server or is still waiting in the pump
+	var got []byte
+	conn.SetReadDeadline(time.Now().Add(testTimeout))
+	for !bytes.HasSuffix(got, []byte("two\r")) {
+		buffer := make([]byte, 64)
+		n, err := conn.Read(buffer)
+		got = append(got, buffer[:n]...)
+		if err != nil {
+			t.Fatalf("second server received %q, then %v", got, err)
+		}
+	}
+	if string(got) != "two\r" && string(got) != "one\rtwo\r" {
+		t.Errorf("second server received %q, want %q", got, "two\r")
+	}
+
+	conn.Close()
+	if result := waitSession(t, done); !errors.Is(result.err, ErrServerClosed) {
+		t.Errorf("second session ended with %v, want %v", result.err, ErrServerClosed)
+	}
+}
+
+// TestPooledPayloadsUnderLoad streams output with telnet commands mixed
+// in through two back-to-back sessions, with the stall watchdog and
+// -fail-on also looking at each payload. Run it with -race: a payload
+// used after it went back to the pool is reported, or garbles the output.
+func TestPooledPayloadsUnderLoad(t *testing.T) {
+	client := newTestClient(t, NewOptions("127.0.0.1", 513, "sysop", "", WithLocalName("me"), WithQuiet()))
+	client.stallTimeout = time.Minute
+	client.failOn = "login incorrect"
+	client.failOnWindow = time.Minute
+	conns := pipeServers(t, client)
+	input, _ := openInput(t)
+	output := &syncBuffer{}
+
+	var want bytes.Buffer
+	for session := 0; session < 2; session++ {
+		done := startSession(client, input, output)
+		conn := nextConn(t, conns)
+		readHandshake(t, conn, len(testHandshake))
+
+		// The client answers IAC DO NAWS, so read its replies as we go
+		go io.Copy(io.Discard, conn)
+		for i := 0; i < 500; i++ {
+			chunk := bytes.Repeat([]byte{byte('a' + (session*500+i)%26)}, 1+i%300)
+			want.Write(chunk)
+			if i%10 == 0 {
+				chunk = append(chunk, telnetIAC, telnetDO, optionNAWS)
+			}
+			if _, err := conn.Write(chunk); err != nil {
+				t.Fatalf("failed to write chunk %d: %v", i, err)
+			}
+		}
+		conn.Close()
+		if result := waitSession(t, done); !errors.Is(result.err, ErrServerClosed) {
+			t.Fatalf("session %d ended with %v, want %v", session+1, result.err, ErrServerClosed)
+		}
+	}
+
+	if diff := goldenDiff("output", want.Bytes(), []byte(output.String())); diff != "" {
+		t.Error(diff)
+	}
+}
+
+// TestStallReconnectsByDefault checks that a stall reconnects even with
+// the default -retries 0, and that a second stall then exits with the
+// timeout status.
+func TestStallReconnectsByDefault(t *testing.T) {
+	client := newTestClient(t, NewOptions("127.0.0.1", 513, "sysop", "", WithLocalName("me"), WithQuiet()))
+	client.stallTimeout = 50 * time.Millisecond
+	client.retryDelay = time.Millisecond
+	conns := pipeServers(t, client)
+	input, _ := openInput(t)
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := client.runWithRetries(context.Background(), input, io.Discard)
+		done <- err
+	}()
+	for session := 0; session < 2; session++ {
+		conn := nextConn(t, conns)
+		readHandshake(t, conn, len(testHandshake))
+		// Break off inside an escape sequence, as a board hung mid-screen does
+		conn.Write([]byte("\x1b[1;"))
+	}
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, errStalled) {
+			t.Errorf("session ended with %v, want %v", err, errStalled)
+		}
+		if code := ExitCode(err); code != ExitTimeout {
+			t.Errorf("ExitCode(%v) = %d, want %d", err, code, ExitTimeout)
+		}
+	case <-time.After(testTimeout):
+		t.Fatal("session did not end")
+	}
+	select {
+	case <-conns:
+		t.Error("client reconnected a second time with -retries 0")
+	default:
+	}
+}
+
+// benchmarkChunk is how much the fake server writes at a time. net.Pipe
+// reads return at most one write, so this keeps reads short of the buffer
+// size and out of readServerData's full-buffer pause.
+const benchmarkChunk = 1024
+
+// serveScreens writes screen n times in benchmarkChunk writes, then hangs up.
+func serveScreens(conn net.Conn, screen []byte, n int) {
+	defer conn.Close()
+	for i := 0; i < n; i++ {
+		for data := screen; len(data) > 0; {
+			chunk := data[:min(benchmarkChunk, len(data))]
+			if _, err := conn.Write(chunk); err != nil {
+				return
+			}
+			data = data[len(chunk):]
+		}
+	}
+}
+
+func BenchmarkReadServerData(b *testing.B) {
+	screen := benchmarkScreen(64<<10, true)
+	client, err := NewTelnetClient(NewOptions("127.0.0.1", 513, "sysop", "", WithQuiet()))
+	if err != nil {
+		b.Fatal(err)
+	}
+	client.SetLogger(log.New(io.Discard, "", 0))
+	clientEnd, serverEnd := net.Pipe()
+	defer clientEnd.Close()
+
+	// The fake server doesn't read, so negotiation replies go nowhere
+	negotiator := &telnetNegotiator{writer: io.Discard, windowSize: client.windowSize}
+	reader := newServerReader(clientEnd, negotiator, client.bufferSize)
+	received := make(chan serverChunk)
+	closeSignal := make(chan bool, 1)
+
+	b.SetBytes(int64(len(screen)))
+	b.ReportAllocs()
+	go serveScreens(serverEnd, screen, b.N)
+	go client.readServerData(context.Background(), reader, received, closeSignal)
+	for chunk := range received {
+		releasePayload(chunk.payload)
+	}
+}
+
+// BenchmarkSession measures a whole session, from the server's bytes
+// arriving to the output writer, with the writer chain each mode sets up.
+func BenchmarkSession(b *testing.B) {
+	modes := []struct {
+		name  string
+		setup func(client *TelnetClient)
+	}{
+		{"raw", func(client *TelnetClient) {}},
+		{"cp437", func(client *TelnetClient) { client.encoding = "cp437" }},
+		{"utf8", func(client *TelnetClient) { client.encoding = "utf8" }},
+		{"cp437 color-downgrade", func(client *TelnetClient) {
+			client.encoding = "cp437"
+			client.colorDowngrade = "16"
+		}},
+		{"cp437 plain wrap", func(client *TelnetClient) {
+			client.encoding = "cp437"
+			client.plain = true
+			client.wrap = 80
+		}},
+	}
+
+	screen := benchmarkScreen(64<<10, false)
+	for _, mode := range modes {
+		b.Run(mode.name, func(b *testing.B) {
+			client, err := NewTelnetClient(NewOptions("127.0.0.1", 513, "sysop", "", WithLocalName("me"), WithTimeout(0), WithQuiet()))
+			if err != nil {
+				b.Fatal(err)
+			}
+			client.SetLogger(log.New(io.Discard, "", 0))
+			mode.setup(client)
+			clientEnd, serverEnd := net.Pipe()
+			client.dialer = func() (net.Conn, error) { return clientEnd, nil }
+
+			go func() {
+				handshake := make([]byte, len(testHandshake))
+				if _, err := io.ReadFull(serverEnd, handshake); err != nil {
+					return
+				}
+				serverEnd.Write([]byte{0})
+				serveScreens(serverEnd, screen, b.N)
+			}()
+
+			input, endInput := io.Pipe()
+			defer endInput.Close()
+			b.SetBytes(int64(len(screen)))
+			b.ReportAllocs()
+			if _, err := client.ProcessData(input, io.Discard); !errors.Is(err, ErrServerClosed) {
+				b.Fatalf("session ended with %v", err)
+			}
+		})
+	}
+}
+
+func TestCreateTCPAddr(t *testing.T) {
+	tests := []struct {
+		host string
+		port uint64
+		want string
+	}{
+		{"127.0.0.1", 2513, "127.0.0.1:2513"},
+		{"bbs.example.com", 513, "bbs.example.com:513"},
+		{"bbs.example.com:2513", 513, "bbs.example.com:2513"},
+		{"::1", 513, "[::1]:513"},
+		{"[::1]", 513, "[::1]:513"},
+		{"[::1]:2513", 513, "[::1]:2513"},
+		{"2001:db8::5", 65535, "[2001:db8::5]:65535"},
+	}
+	for _, tt := range tests {
+		if got := createTCPAddr(tt.host, tt.port); got != tt.want {
+			t.Errorf("createTCPAddr(%q, %d) = %q, want %q", tt.host, tt.port, got, tt.want)
+		}
+	}
+}
+
+func TestHostLiteral(t *testing.T) {
+	tests := []struct {
+		host          string
+		want          string
+		wantNeedsPort bool
+	}{
+		{"127.0.0.1", "127.0.0.1", true},
+		{"bbs.example.com", "bbs.example.com", true},
+		{"bbs.example.com:2513", "bbs.example.com", false},
+		{"::1", "::1", true},
+		{"[::1]", "::1", true},
+		{"[::1]:513", "::1", false},
+	}
+	for _, tt := range tests {
+		if got := hostLiteral(tt.host); got != tt.want {
+			t.Errorf("hostLiteral(%q) = %q, want %q", tt.host, got, tt.want)
+		}
+		if got := NeedsPort(tt.host); got != tt.wantNeedsPort {
+			t.Errorf("NeedsPort(%q) = %v, want %v", tt.host, got, tt.wantNeedsPort)
+		}
+	}
+
+	if NeedsPort("unix:/run/bbs.sock") {
+		t.Errorf("NeedsPort is true for a Unix socket")
+	}
+	if !NeedsPort("unix:/run/bbs.sock, bbs.example.com") {
+		t.Errorf("NeedsPort is false for a fallback host without a port")
+	}
+}
+
+func TestResolveTargets(t *testing.T) {
+	tests := []struct {
+		host        string
+		wantNetwork string
+		wantAddress string
+	}{
+		{"127.0.0.1", "tcp", "127.0.0.1:513"},
+		{"127.0.0.1:2513", "tcp", "127.0.0.1:2513"},
+		{"::1", "tcp6", "[::1]:513"},
+		{"[::1]", "tcp6", "[::1]:513"},
+		{"[::1]:2513", "tcp6", "[::1]:2513"},
+	}
+	for _, tt := range tests {
+		client := newTestClient(t, NewOptions(tt.host, 513, "sysop", "", WithQuiet()))
+		target := client.targets[0]
+		if target.network != tt.wantNetwork || target.destination.String() != tt.wantAddress {
+			t.Errorf("host %q dials %s %v, want %s %s", tt.host, target.network, target.destination, tt.wantNetwork, tt.wantAddress)
+		}
+	}
+
+	// localhost may resolve to 127.0.0.1 or ::1, depending on the system
+	client := newTestClient(t, NewOptions("localhost:2513", 513, "sysop", "", WithQuiet()))
+	if target := client.targets[0]; !target.destination.IP.IsLoopback() || target.destination.Port != 2513 {
+		t.Errorf("host %q dials %v, want a loopback address on port 2513", "localhost:2513", target.destination)
+	}
+}
+
+// TestReresolveTargets checks that a reconnect looks the host up again
+// rather than reusing the address found at startup.
+func TestReresolveTargets(t *testing.T) {
+	client := newTestClient(t, NewOptions("localhost:2513", 513, "sysop", "", WithQuiet()))
+	client.targets[0].destination = &net.TCPAddr{IP: net.ParseIP("203.0.113.5"), Port: 2513}
+
+	client.reresolveTargets(context.Background())
+	if target := client.targets[0]; !target.destination.IP.IsLoopback() || target.destination.Port != 2513 {
+		t.Errorf("after re-resolving, %q dials %v, want a loopback address on port 2513", "localhost:2513", target.destination)
+	}
+}
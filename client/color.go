@@ -0,0 +1,262 @@
+package client
+
+import (
+	"bytes"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// maxSGRLength bounds how much of an unfinished escape sequence is held
+// back waiting for its final byte; anything longer isn't an SGR sequence
+// worth rewriting and is passed through as is.
+const maxSGRLength = 64
+
+// ansiPalette is the usual VGA rendering of the 16 ANSI colors, used to find
+// the nearest one to a 256-color or truecolor value.
+var ansiPalette = [16][3]int{
+	{0, 0, 0}, {170, 0, 0}, {0, 170, 0}, {170, 85, 0},
+	{0, 0, 170}, {170, 0, 170}, {0, 170, 170}, {170, 170, 170},
+	{85, 85, 85}, {255, 85, 85}, {85, 255, 85}, {255, 255, 85},
+	{85, 85, 255}, {255, 85, 255}, {85, 255, 255}, {255, 255, 255},
+}
+
+// colorDowngrader rewrites the colors in SGR (ESC [ ... m) sequences for
+// terminals with fewer colors: "16" maps 256-color and truecolor values to
+// the nearest of the 16 ANSI colors, "8" also folds the bright colors onto
+// the normal ones, and "mono" drops colors altogether, keeping attributes
+// such as bold and reverse. Sequences split across writes are held back
+// until they are complete.
+type colorDowngrader struct {
+	writer  io.Writer
+	colors  int // 16, 8 or 0 for mono
+	pending []byte
+}
+
+func newColorDowngrader(w io.Writer, mode string) *colorDowngrader {
+	colors := 0
+	switch mode {
+	case "16":
+		colors = 16
+	case "8":
+		colors = 8
+	}
+	return &colorDowngrader{writer: w, colors: colors}
+}
+
+func (d *colorDowngrader) Write(p []byte) (int, error) {
+	data := p
+	if len(d.pending) > 0 {
+		data = append(d.pending, p...)
+		d.pending = nil
+	}
+
+	out := make([]byte, 0, len(data))
+	for len(data) > 0 {
+		i := bytes.IndexByte(data, 0x1b)
+		if i < 0 {
+			out = append(out, data...)
+			break
+		}
+		out = append(out, data[:i]...)
+		data = data[i:]
+
+		end := sgrEnd(data)
+		if end < 0 {
+			if len(data) < maxSGRLength {
+				d.pending = append([]byte(nil), data...)
+				break
+			}
+			end = 1
+		}
+		if end > 0 && data[end-1] == 'm' {
+			out = append(out, d.rewrite(data[2:end-1])...)
+		} else {
+			if end == 0 {
+				end = 1 // not a CSI sequence
+			}
+			out = append(out, data[:end]...)
+		}
+		data = data[end:]
+	}
+
+	if len(out) > 0 {
+		if _, err := d.writer.Write(out); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// sgrEnd returns the length of the CSI sequence at the start of data, -1 if
+// it is unfinished, or 0 if data doesn't start with ESC [.
+func sgrEnd(data []byte) int {
+	if len(data) < 2 {
+		return -1
+	}
+	if data[1] != '[' {
+		return 0
+	}
+	for i := 2; i < len(data); i++ {
+		if data[i] >= 0x40 && data[i] <= 0x7e {
+			return i + 1
+		}
+	}
+	return -1
+}
+
+// rewrite returns the SGR sequence with parameters params, its colors
+// downgraded. Private sequences such as ESC [ > 4 m are left alone.
+func (d *colorDowngrader) rewrite(params []byte) []byte {
+	original := append(append([]byte("\x1b["), params...), 'm')
+	if strings.Trim(string(params), "0123456789;:") != "" {
+		return original
+	}
+
+	fields := strings.Split(string(params), ";")
+	var kept []string
+	for i := 0; i < len(fields); i++ {
+		field := fields[i]
+
+		// Extended colors, either ESC [ 38;5;n m or ESC [ 38:5:n m, and the
+		// same for 2 (truecolor) and for backgrounds (48)
+		colon := strings.Contains(field, ":")
+		ext := fields[i:]
+		if colon {
+			ext = strings.Split(field, ":")
+		}
+		if ext[0] == "38" || ext[0] == "48" {
+			index, used := extendedColor(ext, colon)
+			if !colon {
+				i += used - 1
+			}
+			if index >= 0 {
+				kept = append(kept, d.colorCode(index, ext[0] == "48"))
+			}
+			continue
+		}
+
+		n, err := strconv.Atoi(field)
+		if err != nil {
+			kept = append(kept, field)
+			continue
+		}
+		switch {
+		case n >= 30 && n <= 37:
+			kept = append(kept, d.colorCode(n-30, false))
+		case n >= 40 && n <= 47:
+			kept = append(kept, d.colorCode(n-40, true))
+		case n >= 90 && n <= 97:
+			kept = append(kept, d.colorCode(n-90+8, false))
+		case n >= 100 && n <= 107:
+			kept = append(kept, d.colorCode(n-100+8, true))
+		default:
+			kept = append(kept, field)
+		}
+	}
+
+	// An empty ESC [ m would reset every attribute, so a sequence left
+	// with nothing to do is dropped
+	var nonEmpty []string
+	for _, k := range kept {
+		if k != "" {
+			nonEmpty = append(nonEmpty, k)
+		}
+	}
+	if len(nonEmpty) == 0 {
+		if len(params) == 0 {
+			return original
+		}
+		return nil
+	}
+	return []byte("\x1b[" + strings.Join(nonEmpty, ";") + "m")
+}
+
+// colorCode returns the SGR parameter for one of the 16 ANSI colors in
+// the downgraded palette, or "" in mono.
+func (d *colorDowngrader) colorCode(index int, background bool) string {
+	base := 30
+	if background {
+		base = 40
+	}
+	switch {
+	case d.colors == 0:
+		return ""
+	case d.colors == 8 || index < 8:
+		return strconv.Itoa(base + index%8)
+	default:
+		return strconv.Itoa(base + 60 + index - 8)
+	}
+}
+
+// extendedColor converts a 38/48 color given as fields, starting with the
+// 38 or 48, to the nearest of the 16 ANSI colors. It returns the color, or
+// -1 if it is malformed, and how many fields it took up.
+func extendedColor(fields []string, colon bool) (int, int) {
+	if len(fields) < 2 {
+		return -1, len(fields)
+	}
+	value := func(i int) int {
+		if i >= len(fields) {
+			return -1
+		}
+		n, err := strconv.Atoi(fields[i])
+		if err != nil || n < 0 || n > 255 {
+			return -1
+		}
+		return n
+	}
+
+	switch fields[1] {
+	case "5":
+		n := value(2)
+		if n < 0 {
+			return -1, min(3, len(fields))
+		}
+		return nearestANSI(xterm256RGB(n)), 3
+	case "2":
+		// The colon form may put a color space id before the values
+		first := 2
+		if colon && len(fields) >= 6 {
+			first = 3
+		}
+		r, g, b := value(first), value(first+1), value(first+2)
+		if r < 0 || g < 0 || b < 0 {
+			return -1, min(first+3, len(fields))
+		}
+		return nearestANSI([3]int{r, g, b}), first + 3
+	}
+	return -1, 2
+}
+
+// xterm256RGB returns the RGB value of an xterm 256-color palette entry:
+// the 16 ANSI colors, a 6x6x6 color cube, then a grey ramp.
+func xterm256RGB(n int) [3]int {
+	switch {
+	case n < 16:
+		return ansiPalette[n]
+	case n < 232:
+		levels := [6]int{0, 95, 135, 175, 215, 255}
+		n -= 16
+		return [3]int{levels[n/36], levels[n/6%6], levels[n%6]}
+	default:
+		grey := 8 + 10*(n-232)
+		return [3]int{grey, grey, grey}
+	}
+}
+
+// nearestANSI returns the index of the ANSI color closest to rgb.
+func nearestANSI(rgb [3]int) int {
+	best, bestDistance := 0, -1
+	for i, c := range ansiPalette {
+		distance := 0
+		for j := range c {
+			d := c[j] - rgb[j]
+			distance += d * d
+		}
+		if bestDistance < 0 || distance < bestDistance {
+			best, bestDistance = i, distance
+		}
+	}
+	return best
+}
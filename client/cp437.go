@@ -0,0 +1,91 @@
+package client
+
+import (
+	"io"
+	"unicode/utf8"
+)
+
+// cp437High holds the Unicode equivalents of CP437 bytes 0x80-0xFF.
+// Bytes below 0x80 are plain ASCII so control codes such as ESC pass through.
+var cp437High = []rune("" +
+	"ÇüéâäàåçêëèïîìÄÅ" +
+	"ÉæÆôöòûùÿÖÜ¢£¥₧ƒ" +
+	"áíóúñÑªº¿⌐¬½¼¡«»" +
+	"░▒▓│┤╡╢╖╕╣║╗╝╜╛┐" +
+	"└┴┬├─┼╞╟╚╔╩╦╠═╬╧" +
+	"╨╤╥╙╘╒╓╫╪┘┌█▄▌▐▀" +
+	"αßΓπΣσµτΦΘΩδ∞φε∩" +
+	"≡±≥≤⌠⌡÷≈°∙·√ⁿ²■ ")
+
+// cp437Writer translates CP437 bytes to UTF-8 before writing them to the
+// underlying writer. CP437 is single-byte, so no state is kept between writes.
+type cp437Writer struct {
+	writer io.Writer
+}
+
+func newCP437Writer(w io.Writer) *cp437Writer {
+	return &cp437Writer{writer: w}
+}
+
+// Write translates p and writes the UTF-8 result. It reports len(p) on
+// success even though more bytes may have been written downstream.
+func (w *cp437Writer) Write(p []byte) (int, error) {
+	out := make([]byte, 0, len(p)*2)
+	for _, b := range p {
+		if b < 0x80 {
+			out = append(out, b)
+			continue
+		}
+		var encoded [utf8.UTFMax]byte
+		n := utf8.EncodeRune(encoded[:], cp437High[b-0x80])
+		out = append(out, encoded[:n]...)
+	}
+
+	if _, err := w.writer.Write(out); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// cp437Reverse maps Unicode runes back to their CP437 byte.
+var cp437Reverse = func() map[rune]byte {
+	m := make(map[rune]byte, len(cp437High))
+	for i, r := range cp437High {
+		m[r] = byte(0x80 + i)
+	}
+	return m
+}()
+
+// cp437Encoder converts UTF-8 input to CP437 bytes. Incomplete UTF-8
+// sequences at the end of a chunk are held until the next call.
+type cp437Encoder struct {
+	pending []byte
+}
+
+// Encode translates p, substituting '?' for runes with no CP437 equivalent.
+func (e *cp437Encoder) Encode(p []byte) []byte {
+	data := append(e.pending, p...)
+	e.pending = nil
+
+	out := make([]byte, 0, len(data))
+	for len(data) > 0 {
+		if data[0] < utf8.RuneSelf {
+			out = append(out, data[0])
+			data = data[1:]
+			continue
+		}
+		if !utf8.FullRune(data) {
+			// Wait for the rest of the sequence
+			e.pending = append([]byte(nil), data...)
+			break
+		}
+		r, size := utf8.DecodeRune(data)
+		data = data[size:]
+		if b, ok := cp437Reverse[r]; ok && r != utf8.RuneError {
+			out = append(out, b)
+		} else {
+			out = append(out, '?')
+		}
+	}
+	return out
+}
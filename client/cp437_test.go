@@ -0,0 +1,17 @@
+package client
+
+import (
+	"io"
+	"testing"
+)
+
+func BenchmarkCP437Writer(b *testing.B) {
+	data := benchmarkScreen(4096, false)
+	w := newCP437Writer(io.Discard)
+
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		w.Write(data)
+	}
+}
@@ -0,0 +1,36 @@
+package client
+
+import (
+	"encoding/hex"
+	"log"
+	"net"
+)
+
+// debugConn wraps a connection and dumps every chunk read or written, in
+// hexdump -C format, to a dedicated debug logger. Chunks pass through
+// redact first, so the secret and password are masked in the dumps.
+type debugConn struct {
+	net.Conn
+	logger *log.Logger
+	redact func([]byte) []byte
+}
+
+func (c *debugConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if n > 0 {
+		c.logger.Printf("RECV %d bytes\n%s", n, hex.Dump(c.redact(p[:n])))
+	}
+	return n, err
+}
+
+func (c *debugConn) Write(p []byte) (int, error) {
+	return c.WriteMasked(p, c.redact(p))
+}
+
+func (c *debugConn) WriteMasked(p, shown []byte) (int, error) {
+	n, err := writeMasked(c.Conn, p, shown)
+	if n > 0 {
+		c.logger.Printf("SEND %d bytes\n%s", n, hex.Dump(shown[:n]))
+	}
+	return n, err
+}
@@ -0,0 +1,83 @@
+package client
+
+import (
+	"fmt"
+	"io"
+	"time"
+)
+
+// escapeFilter picks local commands out of the keyboard input, in the style
+// of ssh: the escape character is only recognised at the start of a line,
+// and the character typed after it selects the command.
+type escapeFilter struct {
+	escape      byte
+	atLineStart bool
+	pending     bool
+}
+
+func newEscapeFilter(escape byte) *escapeFilter {
+	return &escapeFilter{escape: escape, atLineStart: true}
+}
+
+// Next scans data for an escape command. It returns the input to send to the
+// server ahead of the command, the command character (0 if none was found)
+// and the input left after it, which should be passed to Next again.
+func (f *escapeFilter) Next(data []byte) (send []byte, command byte, rest []byte) {
+	send = make([]byte, 0, len(data))
+
+	for i, b := range data {
+		if f.pending {
+			f.pending = false
+			switch b {
+			case f.escape:
+				// A doubled escape sends the character itself
+				send = append(send, b)
+				f.atLineStart = false
+			case '.', '?', 's':
+				return send, b, data[i+1:]
+			default:
+				// Not a command, so send the escape along with the character
+				send = append(send, f.escape, b)
+				f.atLineStart = b == '\r' || b == '\n'
+			}
+			continue
+		}
+
+		if f.atLineStart && b == f.escape {
+			f.pending = true
+			continue
+		}
+		send = append(send, b)
+		f.atLineStart = b == '\r' || b == '\n'
+	}
+
+	return send, 0, nil
+}
+
+// escapeHelp lists the escape commands; %c is replaced by the escape character.
+const escapeHelp = "\r\nSupported escape sequences:\r\n" +
+	"  %[1]c.  - disconnect\r\n" +
+	"  %[1]c?  - this message\r\n" +
+	"  %[1]cs  - show connection status\r\n" +
+	"  %[1]c%[1]c  - send the escape character\r\n" +
+	"(Escapes are only recognized immediately after a newline.)\r\n"
+
+// statusClearDelay is how long the ~s status line stays on screen.
+const statusClearDelay = 3 * time.Second
+
+// showStatusLine draws a one-line connection summary in reverse video on the
+// bottom row of the terminal, saving and restoring the cursor around it.
+// A positive remaining is shown as the time left under -max-duration.
+func showStatusLine(w io.Writer, row int, address string, stats SessionStats, elapsed, remaining time.Duration) {
+	status := fmt.Sprintf(" %s | sent %d bytes, received %d bytes | online %v ",
+		address, stats.BytesSent, stats.BytesReceived, elapsed.Round(time.Second))
+	if remaining > 0 {
+		status += fmt.Sprintf("| %v left ", remaining.Round(time.Second))
+	}
+	fmt.Fprintf(w, "\x1b7\x1b[%d;1H\x1b[2K\x1b[7m%s\x1b[0m\x1b8", row, status)
+}
+
+// clearStatusLine blanks the bottom row again once the status has been read.
+func clearStatusLine(w io.Writer, row int) {
+	fmt.Fprintf(w, "\x1b7\x1b[%d;1H\x1b[2K\x1b8", row)
+}
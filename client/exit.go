@@ -0,0 +1,117 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+)
+
+// Exit statuses, so scripts can tell failures apart.
+const (
+	ExitOK        = 0
+	ExitFailure   = 1 // anything not covered below
+	ExitUsage     = 2 // bad or missing arguments
+	ExitResolve   = 3 // the host name could not be resolved
+	ExitConnect   = 4 // the connection was refused or timed out
+	ExitHandshake = 5 // the server rejected or never answered the handshake
+	ExitTimeout   = 6 // idle or stall timeout, no response after the input ended, or -max-duration
+	ExitRejected  = 7 // the board showed the -fail-on login failure text
+	ExitMismatch  = 8 // the session differed from the -golden file
+)
+
+// The kinds of failure NewTelnetClient, Run and ProcessData report, for
+// callers to tell apart with errors.Is. The errors returned match one of
+// these and wrap the underlying cause, such as a *net.DNSError or
+// *net.OpError, for errors.As.
+var (
+	ErrResolve      = errors.New("host name could not be resolved")
+	ErrDial         = errors.New("could not connect to the server")
+	ErrHandshake    = errors.New("rlogin handshake failed")
+	ErrServerClosed = errors.New("server closed the connection")
+	ErrTimeout      = errors.New("session timed out")
+)
+
+// errIdleTimeout, errResponseTimeout and errStalled end a session that
+// -idle-timeout, -timeout or -stall-timeout gave up on, and errMaxDuration
+// one that ran out of -max-duration.
+var (
+	errIdleTimeout     error = &timeoutError{"idle timeout reached"}
+	errResponseTimeout error = &timeoutError{"connection timeout with no response received"}
+	errStalled         error = &timeoutError{"server stopped sending partway through a screen"}
+	errMaxDuration     error = &timeoutError{"maximum session duration reached"}
+)
+
+// errLoginRejected ends a session whose output matched -fail-on.
+var errLoginRejected = errors.New("login rejected")
+
+// timeoutError is one of the ways a session can time out; it matches
+// ErrTimeout.
+type timeoutError struct {
+	message string
+}
+
+func (e *timeoutError) Error() string        { return e.message }
+func (e *timeoutError) Is(target error) bool { return target == ErrTimeout }
+
+// resolveError reports a host name that could not be resolved; it matches
+// ErrResolve.
+type resolveError struct {
+	address string
+	err     error
+}
+
+func (e *resolveError) Error() string {
+	return fmt.Sprintf("error occurred while resolving TCP address \"%v\": %v", e.address, e.err)
+}
+
+func (e *resolveError) Unwrap() error        { return e.err }
+func (e *resolveError) Is(target error) bool { return target == ErrResolve }
+
+// connectError reports a failure to establish the connection; it matches
+// ErrDial.
+type connectError struct {
+	address string
+	err     error
+}
+
+func (e *connectError) Error() string {
+	return fmt.Sprintf("error occurred while connecting to address \"%v\": %v", e.address, e.err)
+}
+
+func (e *connectError) Unwrap() error        { return e.err }
+func (e *connectError) Is(target error) bool { return target == ErrDial }
+
+// handshakeError reports a server that rejected or never answered the
+// rlogin handshake; it matches ErrHandshake.
+type handshakeError struct {
+	err error
+}
+
+func (e *handshakeError) Error() string        { return e.err.Error() }
+func (e *handshakeError) Unwrap() error        { return e.err }
+func (e *handshakeError) Is(target error) bool { return target == ErrHandshake }
+
+// ExitCode returns the exit status for the error a session ended with.
+func ExitCode(err error) int {
+	var dnsErr *net.DNSError
+
+	switch {
+	case err == nil, errors.Is(err, context.Canceled):
+		return ExitOK
+	case errors.Is(err, ErrResolve), errors.As(err, &dnsErr):
+		return ExitResolve
+	case errors.Is(err, ErrDial):
+		return ExitConnect
+	case errors.Is(err, ErrHandshake):
+		return ExitHandshake
+	case errors.Is(err, ErrTimeout):
+		return ExitTimeout
+	case errors.Is(err, errLoginRejected):
+		return ExitRejected
+	case errors.Is(err, errGoldenMismatch):
+		return ExitMismatch
+	default:
+		return ExitFailure
+	}
+}
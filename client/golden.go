@@ -0,0 +1,204 @@
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// errGoldenMismatch ends a -golden run whose session differed from the file.
+var errGoldenMismatch = errors.New("session does not match the golden file")
+
+// goldenSession is what a -golden run captures: the handshake sent and the
+// first bytes the server answered with, exactly as on the wire except that
+// the password and secret are masked in the handshake. It is
+// saved in the -trace-file line format, without timestamps and with all of
+// the response on one line, since the server may split it differently from
+// one run to the next:
+//
+//	# golden session with bbs.example.com:2513
+//	SEND "\x00me\x00sysop\x00\x00"
+//	RECV "\x00\xff\xfd\x18Welcome"
+type goldenSession struct {
+	sent     []byte
+	received []byte
+	// closed is set when the server hung up before the capture was done;
+	// it is not saved
+	closed bool
+}
+
+// captureGolden connects, sends the handshake and reads up to limit bytes,
+// stopping early once the server has been quiet for -timeout.
+func (t *TelnetClient) captureGolden(limit int) (*goldenSession, error) {
+	connection, err := t.dialer()
+	if err != nil {
+		return nil, &connectError{address: t.address, err: err}
+	}
+	if t.traceFile != "" {
+		trace, err := openTraceFile(t.traceFile, t.address)
+		if err != nil {
+			connection.Close()
+			return nil, fmt.Errorf("failed to open trace file %q: %v", t.traceFile, err)
+		}
+		defer trace.Close()
+		connection = &traceConn{Conn: connection, trace: trace, redact: t.redactSent}
+	}
+	defer connection.Close()
+
+	session := &goldenSession{}
+	if !t.rawTelnet {
+		handshake, err := buildHandshake(t.options, t.handshakes[t.handshakeIndex])
+		if err != nil {
+			return nil, err
+		}
+		if _, err := connection.Write([]byte(handshake)); err != nil {
+			return nil, fmt.Errorf("failed to send rlogin handshake: %v", err)
+		}
+		session.sent = t.redactSent([]byte(handshake))
+	}
+
+	buffer := make([]byte, limit)
+	for len(session.received) < limit {
+		// The first byte may take as long as connecting; after that the
+		// response is over once the server goes quiet
+		timeout := t.responseTimeout
+		if len(session.received) == 0 {
+			timeout = t.connectTimeout
+		}
+		if timeout > 0 {
+			connection.SetReadDeadline(time.Now().Add(timeout))
+		}
+
+		n, err := connection.Read(buffer[:limit-len(session.received)])
+		session.received = append(session.received, buffer[:n]...)
+		if err != nil {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				break
+			}
+			if len(session.received) > 0 {
+				session.closed = true
+				break
+			}
+			return nil, fmt.Errorf("error occurred while reading from server: %v", err)
+		}
+	}
+	if len(session.received) == 0 && !t.rawTelnet {
+		return nil, errNoHandshakeResponse
+	}
+	return session, nil
+}
+
+// writeGoldenFile saves session to path.
+func writeGoldenFile(path, address string, session *goldenSession) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# golden session with %s\n", address)
+	fmt.Fprintf(&b, "SEND %q\n", session.sent)
+	fmt.Fprintf(&b, "RECV %q\n", session.received)
+	return os.WriteFile(path, []byte(b.String()), 0600)
+}
+
+// readGoldenFile loads a session saved by writeGoldenFile.
+func readGoldenFile(path string) (*goldenSession, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	session := &goldenSession{}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, MaxBufferSize*4)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := scanner.Text()
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		direction, quoted, _ := strings.Cut(line, " ")
+		data, err := strconv.Unquote(quoted)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: invalid quoted data: %v", path, lineNumber, err)
+		}
+		switch direction {
+		case "SEND":
+			session.sent = append(session.sent, data...)
+		case "RECV":
+			session.received = append(session.received, data...)
+		default:
+			return nil, fmt.Errorf("%s:%d: expected SEND or RECV, got %q", path, lineNumber, direction)
+		}
+	}
+	return session, scanner.Err()
+}
+
+// goldenDiff describes where got differs from want, or returns "" if they
+// are the same.
+func goldenDiff(direction string, want, got []byte) string {
+	if bytes.Equal(want, got) {
+		return ""
+	}
+	at := 0
+	for at < len(want) && at < len(got) && want[at] == got[at] {
+		at++
+	}
+	excerpt := func(p []byte) []byte {
+		start := max(at-8, 0)
+		return p[start:min(at+16, len(p))]
+	}
+	return fmt.Sprintf("%s differs at byte %d (golden %d bytes, now %d): expected %q, got %q",
+		direction, at, len(want), len(got), excerpt(want), excerpt(got))
+}
+
+// RunGolden captures the start of a session and saves it to path, if there
+// is no golden file yet or update is set, or else compares it with the
+// file and reports each difference.
+func (t *TelnetClient) RunGolden(path string, limit int, update bool) error {
+	session, err := t.captureGolden(limit)
+	if err != nil {
+		return err
+	}
+
+	if _, statErr := os.Stat(path); update || errors.Is(statErr, os.ErrNotExist) {
+		if err := writeGoldenFile(path, t.address, session); err != nil {
+			return fmt.Errorf("failed to write golden file %q: %v", path, err)
+		}
+		t.infof("Saved the handshake and %d bytes of response to %s.", len(session.received), path)
+		return nil
+	}
+
+	golden, err := readGoldenFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read golden file %q: %v", path, err)
+	}
+	// A quicker or slower server may answer with less or more within the
+	// limit, so only the part both runs captured is compared
+	n := min(len(golden.received), len(session.received))
+
+	mismatched := false
+	for _, diff := range []string{
+		goldenDiff("SEND", golden.sent, session.sent),
+		goldenDiff("RECV", golden.received[:n], session.received[:n]),
+	} {
+		if diff != "" {
+			t.errorf("%s", diff)
+			mismatched = true
+		}
+	}
+	// A server that hangs up early, e.g. on a rejected login, is a
+	// difference however well the little it sent matches
+	if session.closed && len(session.received) < len(golden.received) {
+		t.errorf("RECV ended at byte %d when the server hung up (golden %d bytes)", len(session.received), len(golden.received))
+		mismatched = true
+	}
+	if mismatched {
+		return fmt.Errorf("%w %q", errGoldenMismatch, path)
+	}
+	t.infof("Session matches %s.", path)
+	return nil
+}
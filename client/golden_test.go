@@ -0,0 +1,69 @@
+package client
+
+import (
+	"errors"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestGoldenShortCapture checks that a server which answers with less than
+// the golden file and hangs up fails the comparison, while one that only
+// goes quiet early is compared on what it sent.
+func TestGoldenShortCapture(t *testing.T) {
+	const timeout = 100 * time.Millisecond
+	const welcome = "\x00Welcome to the board\r\n"
+
+	tests := []struct {
+		name string
+		// server plays the server's part once the handshake is
+		// acknowledged with the NUL that starts the golden response
+		server func(conn net.Conn)
+		want   error
+	}{
+		{
+			name:   "same response",
+			server: func(conn net.Conn) { conn.Write([]byte(welcome[1:])) },
+			want:   nil,
+		},
+		{
+			name:   "quiet after part of it",
+			server: func(conn net.Conn) { conn.Write([]byte(welcome[1:8])) },
+			want:   nil,
+		},
+		{
+			name:   "hangs up after the NUL",
+			server: func(conn net.Conn) { conn.Close() },
+			want:   errGoldenMismatch,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "login.golden")
+			err := writeGoldenFile(path, "127.0.0.1:513", &goldenSession{
+				sent:     []byte(testHandshake),
+				received: []byte(welcome),
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			client, conn := newPipeClient(t, timeout, "exit")
+			done := make(chan error, 1)
+			go func() { done <- client.RunGolden(path, 256, false) }()
+			readHandshake(t, conn, len(testHandshake))
+			tt.server(conn)
+
+			select {
+			case err := <-done:
+				if !errors.Is(err, tt.want) {
+					t.Errorf("RunGolden = %v, want %v", err, tt.want)
+				}
+			case <-time.After(testTimeout):
+				t.Fatal("RunGolden did not finish")
+			}
+		})
+	}
+}
@@ -0,0 +1,114 @@
+package client
+
+import (
+	"errors"
+	"io"
+	"syscall"
+	"time"
+)
+
+const maxInputRetries = 5
+const inputRetryDelay = 10 * time.Millisecond
+
+// inputPump reads local input on one goroutine that outlives individual
+// sessions. Stdin reads can't be interrupted, so a per-session reader would
+// be left blocked, and leaked, every time the connection dropped; it would
+// also swallow the next keystroke typed before the reconnect.
+type inputPump struct {
+	source io.Reader
+	chunks chan []byte
+	err    error // why the input ended; valid once chunks is closed
+}
+
+func newInputPump(source io.Reader, bufferSize int) *inputPump {
+	p := &inputPump{
+		source: source,
+		chunks: make(chan []byte),
+	}
+	go p.run(bufferSize)
+	return p
+}
+
+// run reads until the input fails, retrying interrupted reads. Each chunk
+// is copied out of the read buffer before it is handed on.
+func (p *inputPump) run(bufferSize int) {
+	defer close(p.chunks)
+
+	buffer := make([]byte, bufferSize)
+	failures := 0
+	for {
+		n, err := p.source.Read(buffer)
+		if n > 0 {
+			failures = 0
+			p.chunks <- append([]byte(nil), buffer[:n]...)
+		}
+		if err != nil {
+			if err != io.EOF && isRecoverableReadError(err) && failures < maxInputRetries {
+				failures++
+				time.Sleep(inputRetryDelay)
+				continue
+			}
+			p.err = err
+			return
+		}
+	}
+}
+
+// isRecoverableReadError reports whether a failed input read is worth
+// retrying, such as a read interrupted by a signal.
+func isRecoverableReadError(err error) bool {
+	return errors.Is(err, syscall.EINTR) || errors.Is(err, syscall.EAGAIN)
+}
+
+// inputFor returns the pump reading inputData, starting one the first time
+// the reader is seen so repeated sessions share it.
+func (t *TelnetClient) inputFor(inputData io.Reader) *inputPump {
+	t.inputMu.Lock()
+	defer t.inputMu.Unlock()
+	if t.input == nil || t.input.source != inputData {
+		t.input = newInputPump(inputData, t.bufferSize)
+	}
+	return t.input
+}
+
+// lineEndings maps each -crlf mode to what is sent for a line ending. In
+// auto, the classic BBS convention, Enter is sent as CR.
+var lineEndings = map[string]string{
+	"auto": "\r",
+	"cr":   "\r",
+	"lf":   "\n",
+	"crlf": "\r\n",
+}
+
+// newlineTranslator rewrites line endings in keyboard input. CR, LF and
+// CRLF each count as a single line ending, so a CRLF from the terminal or
+// a piped file isn't sent as two.
+type newlineTranslator struct {
+	ending  string
+	afterCR bool // the previous chunk ended with CR, so a leading LF is its pair
+}
+
+func newNewlineTranslator(mode string) *newlineTranslator {
+	return &newlineTranslator{ending: lineEndings[mode]}
+}
+
+// Translate returns p with its line endings replaced.
+func (n *newlineTranslator) Translate(p []byte) []byte {
+	out := make([]byte, 0, len(p))
+	for _, b := range p {
+		switch b {
+		case '\r':
+			out = append(out, n.ending...)
+			n.afterCR = true
+			continue
+		case '\n':
+			if !n.afterCR {
+				out = append(out, n.ending...)
+			}
+		default:
+			out = append(out, b)
+		}
+		n.afterCR = false
+	}
+	return out
+}
@@ -0,0 +1,100 @@
+package client
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// SessionMetrics mirrors the SessionStats accounting in counters that can be
+// read while the session runs, for the -metrics-addr endpoint. Its methods
+// do nothing on a nil receiver, so the client calls them unconditionally.
+type SessionMetrics struct {
+	bytesSent      atomic.Int64
+	bytesReceived  atomic.Int64
+	reconnects     atomic.Int64
+	connected      atomic.Bool
+	connectedSince atomic.Int64 // UnixNano of the current connection
+	duration       atomic.Int64 // nanoseconds of finished connections
+}
+
+func (m *SessionMetrics) addSent(n int) {
+	if m != nil {
+		m.bytesSent.Add(int64(n))
+	}
+}
+
+func (m *SessionMetrics) addReceived(n int) {
+	if m != nil {
+		m.bytesReceived.Add(int64(n))
+	}
+}
+
+func (m *SessionMetrics) addReconnect() {
+	if m != nil {
+		m.reconnects.Add(1)
+	}
+}
+
+func (m *SessionMetrics) opened() {
+	if m != nil {
+		m.connectedSince.Store(time.Now().UnixNano())
+		m.connected.Store(true)
+	}
+}
+
+func (m *SessionMetrics) closed() {
+	if m != nil && m.connected.Swap(false) {
+		m.duration.Add(time.Now().UnixNano() - m.connectedSince.Load())
+	}
+}
+
+// sessionSeconds returns the total connected time, including the current connection.
+func (m *SessionMetrics) sessionSeconds() float64 {
+	total := m.duration.Load()
+	if m.connected.Load() {
+		total += time.Now().UnixNano() - m.connectedSince.Load()
+	}
+	return time.Duration(total).Seconds()
+}
+
+// ServeHTTP writes the metrics in the Prometheus text exposition format.
+func (m *SessionMetrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	connected := 0
+	if m.connected.Load() {
+		connected = 1
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	fmt.Fprintf(w, "# HELP goldmine_connect_bytes_sent_total Payload bytes sent to the server.\n")
+	fmt.Fprintf(w, "# TYPE goldmine_connect_bytes_sent_total counter\n")
+	fmt.Fprintf(w, "goldmine_connect_bytes_sent_total %d\n", m.bytesSent.Load())
+	fmt.Fprintf(w, "# HELP goldmine_connect_bytes_received_total Payload bytes received from the server.\n")
+	fmt.Fprintf(w, "# TYPE goldmine_connect_bytes_received_total counter\n")
+	fmt.Fprintf(w, "goldmine_connect_bytes_received_total %d\n", m.bytesReceived.Load())
+	fmt.Fprintf(w, "# HELP goldmine_connect_connected Whether a connection to the server is open.\n")
+	fmt.Fprintf(w, "# TYPE goldmine_connect_connected gauge\n")
+	fmt.Fprintf(w, "goldmine_connect_connected %d\n", connected)
+	fmt.Fprintf(w, "# HELP goldmine_connect_reconnects_total Reconnects after a dropped connection.\n")
+	fmt.Fprintf(w, "# TYPE goldmine_connect_reconnects_total counter\n")
+	fmt.Fprintf(w, "goldmine_connect_reconnects_total %d\n", m.reconnects.Load())
+	fmt.Fprintf(w, "# HELP goldmine_connect_session_duration_seconds Time spent connected.\n")
+	fmt.Fprintf(w, "# TYPE goldmine_connect_session_duration_seconds counter\n")
+	fmt.Fprintf(w, "goldmine_connect_session_duration_seconds %g\n", m.sessionSeconds())
+}
+
+// ServeMetrics listens on addr and serves m at /metrics in the background.
+// Listening happens up front so a bad address is reported immediately.
+func ServeMetrics(addr string, m *SessionMetrics) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("error starting metrics server: %v", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", m)
+	go http.Serve(listener, mux)
+	return nil
+}
@@ -0,0 +1,125 @@
+package client
+
+import (
+	"os"
+	"sync"
+	"time"
+)
+
+// mirrorBacklog is how many chunks of output may queue for a slow mirror
+// before further chunks are dropped.
+const mirrorBacklog = 256
+
+// mirrorReaderPoll is how often a named pipe with no reader yet is tried
+// again, and mirrorCloseTimeout how long Close waits for a viewer that has
+// stopped reading before cutting it off.
+const (
+	mirrorReaderPoll   = 100 * time.Millisecond
+	mirrorCloseTimeout = time.Second
+)
+
+// mirrorWriter copies the session output to a second destination, such as
+// a file or a named pipe read by a viewer, from its own goroutine. Writes
+// never block: if the mirror falls behind, chunks are dropped rather than
+// holding up the terminal.
+type mirrorWriter struct {
+	chunks  chan []byte
+	closing chan struct{}
+	done    chan struct{}
+	dropped int
+	errorf  func(format string, v ...interface{})
+
+	mu   sync.Mutex
+	file *os.File
+}
+
+// newMirrorWriter starts mirroring to path. The file is opened in the
+// background, since a named pipe can't be opened until it has a reader.
+func newMirrorWriter(path string, errorf func(format string, v ...interface{})) *mirrorWriter {
+	m := &mirrorWriter{
+		chunks:  make(chan []byte, mirrorBacklog),
+		closing: make(chan struct{}),
+		done:    make(chan struct{}),
+		errorf:  errorf,
+	}
+	go m.run(path)
+	return m
+}
+
+func (m *mirrorWriter) run(path string) {
+	defer close(m.done)
+
+	file, err := m.open(path)
+	if err != nil || file == nil {
+		if err != nil {
+			m.errorf("Failed to open mirror %q: %v", path, err)
+		}
+		for range m.chunks {
+		}
+		return
+	}
+	m.mu.Lock()
+	m.file = file
+	m.mu.Unlock()
+
+	failed := false
+	for chunk := range m.chunks {
+		if failed {
+			continue
+		}
+		if _, err := file.Write(chunk); err != nil {
+			// The viewer went away; the session carries on without it
+			m.errorf("Mirror stopped: %v", err)
+			failed = true
+		}
+	}
+	file.Close()
+}
+
+// open opens path for writing without blocking on a named pipe that has no
+// reader, trying again until one turns up. It returns a nil file if the
+// mirror is closed first.
+func (m *mirrorWriter) open(path string) (*os.File, error) {
+	for {
+		file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY|mirrorOpenFlags, 0600)
+		if !mirrorNoReader(err) {
+			return file, err
+		}
+		select {
+		case <-time.After(mirrorReaderPoll):
+		case <-m.closing:
+			return nil, nil
+		}
+	}
+}
+
+// Write queues a copy of p for the mirror, or drops it if the queue is full.
+func (m *mirrorWriter) Write(p []byte) (int, error) {
+	select {
+	case m.chunks <- append([]byte(nil), p...):
+	default:
+		m.dropped++
+	}
+	return len(p), nil
+}
+
+// Close flushes what is queued and closes the mirror. If a named pipe
+// still has no reader it is given up on, and a reader that has stopped
+// reading is cut off after mirrorCloseTimeout.
+func (m *mirrorWriter) Close() error {
+	close(m.chunks)
+	close(m.closing)
+	select {
+	case <-m.done:
+	case <-time.After(mirrorCloseTimeout):
+		// Closing the file fails the pending write
+		m.mu.Lock()
+		m.file.Close()
+		m.mu.Unlock()
+		<-m.done
+	}
+	if m.dropped > 0 {
+		m.errorf("Mirror fell behind; %d chunks of output were dropped", m.dropped)
+	}
+	return nil
+}
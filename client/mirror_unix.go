@@ -0,0 +1,18 @@
+//go:build !windows
+// +build !windows
+
+package client
+
+import (
+	"errors"
+	"syscall"
+)
+
+// mirrorOpenFlags makes opening a named pipe fail straight away with ENXIO
+// when it has no reader, rather than blocking until one arrives.
+const mirrorOpenFlags = syscall.O_NONBLOCK
+
+// mirrorNoReader reports whether err is a named pipe's lack of a reader.
+func mirrorNoReader(err error) bool {
+	return errors.Is(err, syscall.ENXIO)
+}
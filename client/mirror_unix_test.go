@@ -0,0 +1,67 @@
+//go:build !windows
+// +build !windows
+
+package client
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// closeMirror closes m and checks that its goroutine is gone.
+func closeMirror(t *testing.T, m *mirrorWriter) {
+	t.Helper()
+	closed := make(chan struct{})
+	go func() {
+		m.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(testTimeout):
+		t.Fatal("Close did not return")
+	}
+	select {
+	case <-m.done:
+	default:
+		t.Error("mirror goroutine still running after Close")
+	}
+}
+
+// TestMirrorPipeWithoutReader checks that a named pipe nobody opens for
+// reading doesn't leave the mirror's goroutine blocked after Close.
+func TestMirrorPipeWithoutReader(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "watch")
+	if err := syscall.Mkfifo(path, 0600); err != nil {
+		t.Skipf("mkfifo: %v", err)
+	}
+	m := newMirrorWriter(path, t.Logf)
+	m.Write([]byte("Welcome\r\n"))
+	time.Sleep(2 * mirrorReaderPoll)
+	closeMirror(t, m)
+}
+
+// TestMirrorPipeReaderStops checks that Close cuts off a reader that has
+// the pipe open but has stopped reading, once the pipe is full.
+func TestMirrorPipeReaderStops(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "watch")
+	if err := syscall.Mkfifo(path, 0600); err != nil {
+		t.Skipf("mkfifo: %v", err)
+	}
+	reader, err := os.OpenFile(path, os.O_RDONLY|syscall.O_NONBLOCK, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+
+	m := newMirrorWriter(path, t.Logf)
+	chunk := bytes.Repeat([]byte("x"), 4096)
+	for i := 0; i < mirrorBacklog; i++ {
+		m.Write(chunk)
+	}
+	closeMirror(t, m)
+}
@@ -0,0 +1,11 @@
+//go:build windows
+// +build windows
+
+package client
+
+// mirrorOpenFlags adds nothing on Windows, where opening the mirror never
+// waits for a reader.
+const mirrorOpenFlags = 0
+
+// mirrorNoReader is always false on Windows.
+func mirrorNoReader(err error) bool { return false }
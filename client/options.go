@@ -0,0 +1,624 @@
+package client
+
+import (
+	"os"
+	"os/user"
+	"time"
+)
+
+// Options holds the settings a TelnetClient is created with, as built by
+// NewOptions from Option values.
+type Options interface {
+	Host() string
+	Port() uint64
+	Timeout() time.Duration
+	Name() string
+	Xtrn() *string
+	Tag() *string
+	Pass() *string
+	TermType() string
+	Cols() int
+	Rows() int
+	Network() string
+	Retries() int
+	RetryDelay() time.Duration
+	Proxy() string
+	LocalName() string
+	Encoding() string
+	Record() string
+	BufferSize() int
+	KeepAlive() time.Duration
+	IdleTimeout() time.Duration
+	TLS() bool
+	TLSInsecure() bool
+	TLSServerName() string
+	Debug() bool
+	Quiet() bool
+	Script() string
+	ScriptTimeout() time.Duration
+	Plain() bool
+	EmulateBaud() int
+	ConnectTimeout() time.Duration
+	NoCompress() bool
+	Escape() string
+	PasteDelay() time.Duration
+	PasteChunk() int
+	BracketedPaste() bool
+	LogFile() string
+	HandshakeTemplate() string
+	RloginStrict() bool
+	AntiIdle() time.Duration
+	AntiIdleBytes() string
+	RawTelnet() bool
+	Secret() string
+	CaptureScreens() string
+	Init() string
+	InitOnReconnect() bool
+	NoZmodemDetect() bool
+	ZmodemRz() string
+	ZmodemDownloadDir() string
+	ZmodemSz() string
+	ZmodemUpload() string
+	CRLF() string
+	LocalEcho() bool
+	BannerFile() string
+	ClearOnConnect() bool
+	TraceFile() string
+	HandshakeAlternates() string
+	RejectWindow() time.Duration
+	TimeoutAction() string
+	TypeDelay() time.Duration
+	RloginWinch() bool
+	SkipAck() bool
+	SessionLogDir() string
+	SessionLogKeep() int
+	Mirror() string
+	MaxDuration() time.Duration
+	MaxDurationWarn() time.Duration
+	XtrnSequence() string
+	StallTimeout() time.Duration
+	ColorDowngrade() string
+	DNSRetries() int
+	Bind() string
+	Wrap() int
+	FailOn() string
+	FailOnWindow() time.Duration
+}
+
+// settings is the Options built by NewOptions.
+type settings struct {
+	host                string
+	port                uint64
+	name                string
+	tag                 *string
+	xtrn                *string
+	timeout             time.Duration
+	pass                *string
+	termType            string
+	cols                int
+	rows                int
+	network             string
+	retries             int
+	retryDelay          time.Duration
+	proxy               string
+	localName           string
+	encoding            string
+	record              string
+	bufferSize          int
+	keepAlive           time.Duration
+	idleTimeout         time.Duration
+	tls                 bool
+	tlsInsecure         bool
+	tlsServerName       string
+	debug               bool
+	quiet               bool
+	script              string
+	scriptTimeout       time.Duration
+	plain               bool
+	emulateBaud         int
+	connectTimeout      time.Duration
+	noCompress          bool
+	escape              string
+	pasteDelay          time.Duration
+	pasteChunk          int
+	bracketedPaste      bool
+	logFile             string
+	handshakeTemplate   string
+	rloginStrict        bool
+	antiIdle            time.Duration
+	antiIdleBytes       string
+	rawTelnet           bool
+	secret              string
+	captureScreens      string
+	initText            string
+	initOnReconnect     bool
+	noZmodemDetect      bool
+	zmodemRz            string
+	zmodemDownloadDir   string
+	zmodemSz            string
+	zmodemUpload        string
+	crlf                string
+	localEcho           bool
+	bannerFile          string
+	clearOnConnect      bool
+	traceFile           string
+	handshakeAlternates string
+	rejectWindow        time.Duration
+	timeoutAction       string
+	typeDelay           time.Duration
+	rloginWinch         bool
+	skipAck             bool
+	sessionLogDir       string
+	sessionLogKeep      int
+	mirror              string
+	maxDuration         time.Duration
+	maxDurationWarn     time.Duration
+	xtrnSequence        string
+	stallTimeout        time.Duration
+	colorDowngrade      string
+	dnsRetries          int
+	bind                string
+	wrap                int
+	failOn              string
+	failOnWindow        time.Duration
+}
+
+func (s *settings) Host() string                   { return s.host }
+func (s *settings) Port() uint64                   { return s.port }
+func (s *settings) Timeout() time.Duration         { return s.timeout }
+func (s *settings) Name() string                   { return s.name }
+func (s *settings) Xtrn() *string                  { return s.xtrn }
+func (s *settings) Tag() *string                   { return s.tag }
+func (s *settings) Pass() *string                  { return s.pass }
+func (s *settings) TermType() string               { return s.termType }
+func (s *settings) Cols() int                      { return s.cols }
+func (s *settings) Rows() int                      { return s.rows }
+func (s *settings) Network() string                { return s.network }
+func (s *settings) Retries() int                   { return s.retries }
+func (s *settings) RetryDelay() time.Duration      { return s.retryDelay }
+func (s *settings) Proxy() string                  { return s.proxy }
+func (s *settings) LocalName() string              { return s.localName }
+func (s *settings) Encoding() string               { return s.encoding }
+func (s *settings) Record() string                 { return s.record }
+func (s *settings) BufferSize() int                { return s.bufferSize }
+func (s *settings) KeepAlive() time.Duration       { return s.keepAlive }
+func (s *settings) IdleTimeout() time.Duration     { return s.idleTimeout }
+func (s *settings) TLS() bool                      { return s.tls }
+func (s *settings) TLSInsecure() bool              { return s.tlsInsecure }
+func (s *settings) TLSServerName() string          { return s.tlsServerName }
+func (s *settings) Debug() bool                    { return s.debug }
+func (s *settings) Quiet() bool                    { return s.quiet }
+func (s *settings) Script() string                 { return s.script }
+func (s *settings) ScriptTimeout() time.Duration   { return s.scriptTimeout }
+func (s *settings) Plain() bool                    { return s.plain }
+func (s *settings) EmulateBaud() int               { return s.emulateBaud }
+func (s *settings) ConnectTimeout() time.Duration  { return s.connectTimeout }
+func (s *settings) NoCompress() bool               { return s.noCompress }
+func (s *settings) Escape() string                 { return s.escape }
+func (s *settings) PasteDelay() time.Duration      { return s.pasteDelay }
+func (s *settings) PasteChunk() int                { return s.pasteChunk }
+func (s *settings) BracketedPaste() bool           { return s.bracketedPaste }
+func (s *settings) LogFile() string                { return s.logFile }
+func (s *settings) HandshakeTemplate() string      { return s.handshakeTemplate }
+func (s *settings) RloginStrict() bool             { return s.rloginStrict }
+func (s *settings) AntiIdle() time.Duration        { return s.antiIdle }
+func (s *settings) AntiIdleBytes() string          { return s.antiIdleBytes }
+func (s *settings) RawTelnet() bool                { return s.rawTelnet }
+func (s *settings) Secret() string                 { return s.secret }
+func (s *settings) CaptureScreens() string         { return s.captureScreens }
+func (s *settings) Init() string                   { return s.initText }
+func (s *settings) InitOnReconnect() bool          { return s.initOnReconnect }
+func (s *settings) NoZmodemDetect() bool           { return s.noZmodemDetect }
+func (s *settings) ZmodemRz() string               { return s.zmodemRz }
+func (s *settings) ZmodemDownloadDir() string      { return s.zmodemDownloadDir }
+func (s *settings) ZmodemSz() string               { return s.zmodemSz }
+func (s *settings) ZmodemUpload() string           { return s.zmodemUpload }
+func (s *settings) CRLF() string                   { return s.crlf }
+func (s *settings) LocalEcho() bool                { return s.localEcho }
+func (s *settings) BannerFile() string             { return s.bannerFile }
+func (s *settings) ClearOnConnect() bool           { return s.clearOnConnect }
+func (s *settings) TraceFile() string              { return s.traceFile }
+func (s *settings) HandshakeAlternates() string    { return s.handshakeAlternates }
+func (s *settings) RejectWindow() time.Duration    { return s.rejectWindow }
+func (s *settings) TimeoutAction() string          { return s.timeoutAction }
+func (s *settings) TypeDelay() time.Duration       { return s.typeDelay }
+func (s *settings) RloginWinch() bool              { return s.rloginWinch }
+func (s *settings) SkipAck() bool                  { return s.skipAck }
+func (s *settings) SessionLogDir() string          { return s.sessionLogDir }
+func (s *settings) SessionLogKeep() int            { return s.sessionLogKeep }
+func (s *settings) Mirror() string                 { return s.mirror }
+func (s *settings) MaxDuration() time.Duration     { return s.maxDuration }
+func (s *settings) MaxDurationWarn() time.Duration { return s.maxDurationWarn }
+func (s *settings) XtrnSequence() string           { return s.xtrnSequence }
+func (s *settings) StallTimeout() time.Duration    { return s.stallTimeout }
+func (s *settings) ColorDowngrade() string         { return s.colorDowngrade }
+func (s *settings) DNSRetries() int                { return s.dnsRetries }
+func (s *settings) Bind() string                   { return s.bind }
+func (s *settings) Wrap() int                      { return s.wrap }
+func (s *settings) FailOn() string                 { return s.failOn }
+func (s *settings) FailOnWindow() time.Duration    { return s.failOnWindow }
+
+// Option sets one setting on the Options built by NewOptions.
+type Option func(*settings)
+
+// NewOptions returns Options for connecting to host and port as name, with
+// every other setting at the same default as the goldmine-connect
+// command-line flags.
+func NewOptions(host string, port uint64, name, tag string, opts ...Option) Options {
+	s := &settings{
+		host:            host,
+		port:            port,
+		name:            name,
+		tag:             &tag,
+		xtrn:            new(string),
+		pass:            new(string),
+		timeout:         1 * time.Second,
+		termType:        "ansi-bbs",
+		network:         "tcp",
+		retryDelay:      2 * time.Second,
+		localName:       defaultLocalName(),
+		encoding:        "raw",
+		bufferSize:      DefaultBufferSize,
+		keepAlive:       30 * time.Second,
+		scriptTimeout:   30 * time.Second,
+		connectTimeout:  10 * time.Second,
+		escape:          "~",
+		antiIdleBytes:   "\x00",
+		crlf:            "auto",
+		rejectWindow:    2 * time.Second,
+		timeoutAction:   "exit",
+		maxDurationWarn: time.Minute,
+		failOnWindow:    10 * time.Second,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// WithXtrn sets the GoldMine xtrn code, as -xtrn does.
+func WithXtrn(xtrn string) Option {
+	return func(s *settings) { s.xtrn = &xtrn }
+}
+
+// WithPassword sets the password sent in the handshake, as -password does.
+func WithPassword(password string) Option {
+	return func(s *settings) { s.pass = &password }
+}
+
+// WithLocalName sets the local username sent in the handshake, as -localname does.
+func WithLocalName(localName string) Option {
+	return func(s *settings) { s.localName = localName }
+}
+
+// WithTimeout sets how long to wait for data after input ends, as -timeout does.
+func WithTimeout(timeout time.Duration) Option {
+	return func(s *settings) { s.timeout = timeout }
+}
+
+// WithConnectTimeout bounds connecting and the handshake, as -connect-timeout does.
+func WithConnectTimeout(timeout time.Duration) Option {
+	return func(s *settings) { s.connectTimeout = timeout }
+}
+
+// WithIdleTimeout disconnects after the server is quiet this long, as -idle-timeout does.
+func WithIdleTimeout(timeout time.Duration) Option {
+	return func(s *settings) { s.idleTimeout = timeout }
+}
+
+// WithRetries reconnects up to retries times, starting delay apart, as
+// -retries and -retry-delay do.
+func WithRetries(retries int, delay time.Duration) Option {
+	return func(s *settings) {
+		s.retries = retries
+		s.retryDelay = delay
+	}
+}
+
+// WithTLS connects over TLS, as -tls and -tls-insecure do.
+func WithTLS(insecure bool) Option {
+	return func(s *settings) {
+		s.tls = true
+		s.tlsInsecure = insecure
+	}
+}
+
+// WithProxy connects through a SOCKS5 proxy URL, as -proxy does.
+func WithProxy(proxyURL string) Option {
+	return func(s *settings) { s.proxy = proxyURL }
+}
+
+// WithTermType sets the terminal type reported to the server, as -termtype does.
+func WithTermType(termType string) Option {
+	return func(s *settings) { s.termType = termType }
+}
+
+// WithEncoding sets the character encoding, raw, utf8 or cp437, as -encoding does.
+func WithEncoding(encoding string) Option {
+	return func(s *settings) { s.encoding = encoding }
+}
+
+// WithHandshakeTemplate replaces the handshake layout, as -handshake-template does.
+func WithHandshakeTemplate(template string) Option {
+	return func(s *settings) { s.handshakeTemplate = template }
+}
+
+// WithScript runs the login script at path after the handshake, as -script does.
+func WithScript(path string) Option {
+	return func(s *settings) { s.script = path }
+}
+
+// WithRawTelnet skips the rlogin handshake, as -raw-telnet does.
+func WithRawTelnet() Option {
+	return func(s *settings) { s.rawTelnet = true }
+}
+
+// WithQuiet suppresses informational messages, as -quiet does.
+func WithQuiet() Option {
+	return func(s *settings) { s.quiet = true }
+}
+
+// WithWindowSize reports a fixed terminal size to the server, as -cols and -rows do.
+func WithWindowSize(cols, rows int) Option {
+	return func(s *settings) {
+		s.cols = cols
+		s.rows = rows
+	}
+}
+
+// WithNetwork restricts connecting to tcp4 or tcp6, as -net does.
+func WithNetwork(network string) Option {
+	return func(s *settings) { s.network = network }
+}
+
+// WithRecord records the session to an asciicast file, as -record does.
+func WithRecord(path string) Option {
+	return func(s *settings) { s.record = path }
+}
+
+// WithBufferSize sets the read buffer size, as -bufsize does.
+func WithBufferSize(size int) Option {
+	return func(s *settings) { s.bufferSize = size }
+}
+
+// WithKeepAlive sets the TCP keepalive period, as -keepalive does.
+func WithKeepAlive(period time.Duration) Option {
+	return func(s *settings) { s.keepAlive = period }
+}
+
+// WithTLSServerName sets the name TLS verifies, as -tls-servername does.
+func WithTLSServerName(serverName string) Option {
+	return func(s *settings) { s.tlsServerName = serverName }
+}
+
+// WithDebug hex-dumps everything sent and received, as -debug does.
+func WithDebug() Option {
+	return func(s *settings) { s.debug = true }
+}
+
+// WithScriptTimeout bounds each expect: line of the login script, as -script-timeout does.
+func WithScriptTimeout(timeout time.Duration) Option {
+	return func(s *settings) { s.scriptTimeout = timeout }
+}
+
+// WithPlain strips ANSI escape sequences from the output, as -plain does.
+func WithPlain() Option {
+	return func(s *settings) { s.plain = true }
+}
+
+// WithEmulateBaud limits the output to a modem speed, as -emulate-baud does.
+func WithEmulateBaud(baud int) Option {
+	return func(s *settings) { s.emulateBaud = baud }
+}
+
+// WithNoCompress refuses MCCP2 compression, as -no-compress does.
+func WithNoCompress() Option {
+	return func(s *settings) { s.noCompress = true }
+}
+
+// WithEscape sets the escape character for local commands, as -escape does.
+func WithEscape(escape string) Option {
+	return func(s *settings) { s.escape = escape }
+}
+
+// WithPaste paces writes to the server, as -paste-delay and -paste-chunk do.
+func WithPaste(delay time.Duration, chunk int) Option {
+	return func(s *settings) {
+		s.pasteDelay = delay
+		s.pasteChunk = chunk
+	}
+}
+
+// WithBracketedPaste always brackets pasted input, as -bracketed-paste does.
+func WithBracketedPaste() Option {
+	return func(s *settings) { s.bracketedPaste = true }
+}
+
+// WithLogFile appends a transcript of the session to path, as -log-file does.
+func WithLogFile(path string) Option {
+	return func(s *settings) { s.logFile = path }
+}
+
+// WithRloginStrict requires the handshake acknowledgement, as -rlogin-strict does.
+func WithRloginStrict() Option {
+	return func(s *settings) { s.rloginStrict = true }
+}
+
+// WithAntiIdle sends data after every spell without typing, as -antiidle and
+// -antiidle-bytes do.
+func WithAntiIdle(every time.Duration, data string) Option {
+	return func(s *settings) {
+		s.antiIdle = every
+		s.antiIdleBytes = data
+	}
+}
+
+// WithSecret sets the value of {{secret}} in the handshake template and login
+// script, as -secret-file does.
+func WithSecret(secret string) Option {
+	return func(s *settings) { s.secret = secret }
+}
+
+// WithCaptureScreens saves each rendered screen as text to path, as -capture-screens does.
+func WithCaptureScreens(path string) Option {
+	return func(s *settings) { s.captureScreens = path }
+}
+
+// WithInit sends keys right after connecting, as -init and -init-on-reconnect do.
+func WithInit(keys string, onReconnect bool) Option {
+	return func(s *settings) {
+		s.initText = keys
+		s.initOnReconnect = onReconnect
+	}
+}
+
+// WithNoZmodemDetect turns off Zmodem detection, as -no-zmodem-detect does.
+func WithNoZmodemDetect() Option {
+	return func(s *settings) { s.noZmodemDetect = true }
+}
+
+// WithZmodemRz receives Zmodem downloads with rz, as -zmodem-rz and
+// -zmodem-download-dir do.
+func WithZmodemRz(rz, downloadDir string) Option {
+	return func(s *settings) {
+		s.zmodemRz = rz
+		s.zmodemDownloadDir = downloadDir
+	}
+}
+
+// WithZmodemSz sends the comma-separated upload files with sz, as -zmodem-sz and
+// -zmodem-upload do.
+func WithZmodemSz(sz, upload string) Option {
+	return func(s *settings) {
+		s.zmodemSz = sz
+		s.zmodemUpload = upload
+	}
+}
+
+// WithCRLF sets the line ending sent for Enter, as -crlf does.
+func WithCRLF(mode string) Option {
+	return func(s *settings) { s.crlf = mode }
+}
+
+// WithLocalEcho always echoes typing locally, as -local-echo does.
+func WithLocalEcho() Option {
+	return func(s *settings) { s.localEcho = true }
+}
+
+// WithBannerFile shows the file at path before connecting, as -banner-file does.
+func WithBannerFile(path string) Option {
+	return func(s *settings) { s.bannerFile = path }
+}
+
+// WithClearOnConnect clears the screen when the server first sends, as -clear-on-connect does.
+func WithClearOnConnect() Option {
+	return func(s *settings) { s.clearOnConnect = true }
+}
+
+// WithTraceFile appends the bytes sent and received to path, as -trace-file does.
+func WithTraceFile(path string) Option {
+	return func(s *settings) { s.traceFile = path }
+}
+
+// WithHandshakeAlternates tries the templates in path when the server rejects the
+// handshake, as -handshake-alternates and -reject-window do.
+func WithHandshakeAlternates(path string, rejectWindow time.Duration) Option {
+	return func(s *settings) {
+		s.handshakeAlternates = path
+		s.rejectWindow = rejectWindow
+	}
+}
+
+// WithTimeoutAction sets what happens when -timeout passes with no response, as
+// -timeout-action does.
+func WithTimeoutAction(action string) Option {
+	return func(s *settings) { s.timeoutAction = action }
+}
+
+// WithTypeDelay types scripted text a character at a time, as -type-delay does.
+func WithTypeDelay(delay time.Duration) Option {
+	return func(s *settings) { s.typeDelay = delay }
+}
+
+// WithRloginWinch sends rlogin window-size messages, as -rlogin-winch does.
+func WithRloginWinch() Option {
+	return func(s *settings) { s.rloginWinch = true }
+}
+
+// WithSkipAck waits only briefly for the handshake acknowledgement, as -skip-ack does.
+func WithSkipAck() Option {
+	return func(s *settings) { s.skipAck = true }
+}
+
+// WithSessionLogDir writes each session's transcript to its own file in dir, as
+// -session-log-dir and -session-log-keep do.
+func WithSessionLogDir(dir string, keep int) Option {
+	return func(s *settings) {
+		s.sessionLogDir = dir
+		s.sessionLogKeep = keep
+	}
+}
+
+// WithMirror copies the decoded output to a file or named pipe, as -mirror does.
+func WithMirror(path string) Option {
+	return func(s *settings) { s.mirror = path }
+}
+
+// WithMaxDuration ends the session after max, warning warn beforehand, as
+// -max-duration and -max-duration-warn do.
+func WithMaxDuration(max, warn time.Duration) Option {
+	return func(s *settings) {
+		s.maxDuration = max
+		s.maxDurationWarn = warn
+	}
+}
+
+// WithXtrnSequence visits the comma-separated xtrn codes in turn, as -xtrn-sequence does.
+func WithXtrnSequence(codes string) Option {
+	return func(s *settings) { s.xtrnSequence = codes }
+}
+
+// WithStallTimeout reconnects when output stops mid-screen, as -stall-timeout does.
+func WithStallTimeout(timeout time.Duration) Option {
+	return func(s *settings) { s.stallTimeout = timeout }
+}
+
+// WithColorDowngrade rewrites the server's colors, as -color-downgrade does.
+func WithColorDowngrade(level string) Option {
+	return func(s *settings) { s.colorDowngrade = level }
+}
+
+// WithDNSRetries retries temporary DNS failures, as -dns-retries does.
+func WithDNSRetries(retries int) Option {
+	return func(s *settings) { s.dnsRetries = retries }
+}
+
+// WithBind connects from a local address, as -bind does.
+func WithBind(addr string) Option {
+	return func(s *settings) { s.bind = addr }
+}
+
+// WithWrap wraps plain output at column, as -wrap does.
+func WithWrap(column int) Option {
+	return func(s *settings) { s.wrap = column }
+}
+
+// WithFailOn fails the session if the board shows text within window of
+// connecting, as -fail-on and -fail-on-window do.
+func WithFailOn(text string, window time.Duration) Option {
+	return func(s *settings) {
+		s.failOn = text
+		s.failOnWindow = window
+	}
+}
+
+// defaultLocalName returns the current OS username, or "" if it can't be determined.
+func defaultLocalName() string {
+	if name := os.Getenv("USER"); name != "" {
+		return name
+	}
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+	return ""
+}
@@ -0,0 +1,100 @@
+package client
+
+import (
+	"bytes"
+	"io"
+	"time"
+	"unicode/utf8"
+)
+
+// pacedWriter splits writes into chunks of at most chunk bytes and waits at
+// least delay between them, so a large paste doesn't overrun the input
+// buffer of slower BBS software. A zero chunk or delay disables that limit,
+// so with both zero writes pass straight through.
+type pacedWriter struct {
+	writer io.Writer
+	chunk  int
+	delay  time.Duration
+	last   time.Time
+}
+
+func newPacedWriter(w io.Writer, chunk int, delay time.Duration) *pacedWriter {
+	return &pacedWriter{writer: w, chunk: chunk, delay: delay}
+}
+
+// Write sends p, split into chunks as configured.
+func (w *pacedWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := len(p)
+		if w.chunk > 0 && n > w.chunk {
+			n = w.chunk
+		}
+
+		m, err := w.WriteUnsplit(p[:n])
+		written += m
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
+
+// WriteUnsplit sends p in a single write, after the configured delay, for
+// sequences such as paste markers that must not be broken up.
+func (w *pacedWriter) WriteUnsplit(p []byte) (int, error) {
+	if w.delay > 0 && !w.last.IsZero() {
+		if wait := w.delay - time.Since(w.last); wait > 0 {
+			time.Sleep(wait)
+		}
+	}
+	n, err := w.writer.Write(p)
+	w.last = time.Now()
+	return n, err
+}
+
+// Bracketed paste markers (xterm DECSET 2004).
+var (
+	pasteStart       = []byte("\x1b[200~")
+	pasteEnd         = []byte("\x1b[201~")
+	pasteModeEnable  = []byte("\x1b[?2004h")
+	pasteModeDisable = []byte("\x1b[?2004l")
+)
+
+// pasteModeDetector watches the server output for the sequences that turn
+// bracketed paste mode on and off. The end of each chunk is kept so a
+// sequence split across reads is still seen.
+type pasteModeDetector struct {
+	enabled bool
+	tail    []byte
+}
+
+// Scan updates the mode from the server output in p.
+func (d *pasteModeDetector) Scan(p []byte) {
+	data := append(d.tail, p...)
+
+	on := bytes.LastIndex(data, pasteModeEnable)
+	off := bytes.LastIndex(data, pasteModeDisable)
+	if on > off {
+		d.enabled = true
+	} else if off > on {
+		d.enabled = false
+	}
+
+	keep := len(pasteModeEnable) - 1
+	if len(data) < keep {
+		keep = len(data)
+	}
+	d.tail = append([]byte(nil), data[len(data)-keep:]...)
+}
+
+// isPaste reports whether a chunk of keyboard input looks like pasted text
+// rather than a single keystroke: one character, a key's escape sequence
+// such as an arrow key, or the CR LF some terminals send for Enter.
+func isPaste(p []byte) bool {
+	if len(p) == 0 || p[0] == 0x1b || string(p) == "\r\n" {
+		return false
+	}
+	return utf8.RuneCount(p) > 1
+}
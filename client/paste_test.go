@@ -0,0 +1,60 @@
+package client
+
+import (
+	"io"
+	"testing"
+	"time"
+)
+
+func TestIsPaste(t *testing.T) {
+	tests := []struct {
+		input string
+		want  bool
+	}{
+		{"a", false},
+		{"é", false},
+		{"\r", false},
+		{"\r\n", false},
+		{"\x1b[A", false},
+		{"\x1bOP", false},
+		{"hello world", true},
+		{"line one\r\nline two\r\n", true},
+		{"ab", true},
+	}
+	for _, tt := range tests {
+		if got := isPaste([]byte(tt.input)); got != tt.want {
+			t.Errorf("isPaste(%q) = %v, want %v", tt.input, got, tt.want)
+		}
+	}
+}
+
+// TestBracketedPasteEnter checks that with -crlf crlf a single Enter, sent
+// as CR LF, isn't wrapped in paste markers while real pastes are.
+func TestBracketedPasteEnter(t *testing.T) {
+	client, conn := newPipeClient(t, time.Second, "exit")
+	client.bracketedPaste = true
+	client.crlf = "crlf"
+	input, typed := io.Pipe()
+	t.Cleanup(func() { typed.Close() })
+	done := startSession(client, input, io.Discard)
+	readHandshake(t, conn, len(testHandshake))
+
+	expect := func(typing, want string) {
+		t.Helper()
+		go typed.Write([]byte(typing))
+		got := make([]byte, len(want))
+		conn.SetReadDeadline(time.Now().Add(testTimeout))
+		if _, err := io.ReadFull(conn, got); err != nil {
+			t.Fatalf("typing %q: server received %q, then %v", typing, got, err)
+		}
+		if string(got) != want {
+			t.Errorf("typing %q: server received %q, want %q", typing, got, want)
+		}
+	}
+	expect("\r", "\r\n")
+	expect("\x1b[A", "\x1b[A")
+	expect("two\rlines\r", "\x1b[200~two\r\nlines\r\n\x1b[201~")
+
+	conn.Close()
+	waitSession(t, done)
+}
@@ -0,0 +1,9 @@
+//go:build windows
+// +build windows
+
+package client
+
+import "os"
+
+// notifyResize is a no-op on Windows, which has no SIGWINCH.
+func notifyResize(c chan<- os.Signal) {}
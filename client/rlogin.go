@@ -0,0 +1,185 @@
+package client
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/template"
+)
+
+// handshakeFields are the values available to a -handshake-template.
+type handshakeFields struct {
+	LocalName  string // local username, or the password when one is given
+	RemoteName string
+	Tag        string
+	Xtrn       string
+	Password   string
+	Secret     string // from -secret-file or -prompt-secret
+}
+
+// handshakeFuncs are the helper functions available to a -handshake-template.
+// renderHandshake replaces secret with one returning the actual secret.
+var handshakeFuncs = template.FuncMap{
+	"null":   func() string { return "\x00" },
+	"secret": func() string { return "" },
+}
+
+// ParseHandshakeTemplate parses a -handshake-template, where {{null}}
+// writes a NUL separator and {{secret}}, as in login scripts, the secret.
+func ParseHandshakeTemplate(text string) (*template.Template, error) {
+	return template.New("handshake").Funcs(handshakeFuncs).Parse(text)
+}
+
+// buildHandshake returns the rlogin handshake for the given options:
+// NUL, local username, NUL, remote username, NUL, terminal type, NUL.
+// A non-empty template, such as -handshake-template, replaces this layout.
+func buildHandshake(options Options, template string) (string, error) {
+	// Conditionally include xtrn if it's provided
+	localUsername := options.LocalName() // Local (client-side) username
+	remoteUsername := options.Name()     // Use the name from the options
+
+	// A password takes the local username slot, as GoldMine expects
+	if options.Pass() != nil && *options.Pass() != "" {
+		localUsername = *options.Pass()
+	}
+
+	if template != "" {
+		return renderHandshake(template, handshakeFields{
+			LocalName:  localUsername,
+			RemoteName: remoteUsername,
+			Tag:        stringValue(options.Tag()),
+			Xtrn:       stringValue(options.Xtrn()),
+			Password:   stringValue(options.Pass()),
+			Secret:     options.Secret(),
+		})
+	}
+
+	handshake := ""
+	if options.Tag() != nil && *options.Tag() != "" {
+		tag := options.Tag()
+		handshake += fmt.Sprintf("\x00%s\x00[%s]%s\x00", localUsername, *tag, remoteUsername)
+	} else {
+		handshake += fmt.Sprintf("\x00%s\x00%s\x00", localUsername, remoteUsername)
+	}
+	// Check if xtrn (termtype) is provided
+	if options.Xtrn() != nil && *options.Xtrn() != "" {
+		handshake += "xtrn=" + *options.Xtrn() + "\x00"
+	} else {
+		// Send an empty string followed by a null character for termtype if not provided
+		handshake += "\x00"
+	}
+
+	return handshake, nil
+}
+
+// xtrnOptions overrides the xtrn code of Options, for each door in turn
+// of -xtrn-sequence.
+type xtrnOptions struct {
+	Options
+	xtrn string
+}
+
+func (o xtrnOptions) Xtrn() *string { return &o.xtrn }
+
+// rloginWindowMessage returns the rlogin window-change control message
+// (RFC 1282): two 0xFF bytes, "ss", then rows, columns and the pixel size,
+// left as zero, each as a 16-bit big-endian value.
+func rloginWindowMessage(cols, rows int) []byte {
+	return []byte{
+		0xff, 0xff, 's', 's',
+		byte(rows >> 8), byte(rows),
+		byte(cols >> 8), byte(cols),
+		0, 0, 0, 0,
+	}
+}
+
+// defaultHandshake names the built-in handshake layout in a
+// -handshake-alternates file.
+const defaultHandshake = "default"
+
+// loadHandshakeTemplates reads a -handshake-alternates file: one template
+// per line, with blank lines and lines starting with # ignored. A line
+// reading "default" stands for the built-in layout, returned as "".
+func loadHandshakeTemplates(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening handshake alternates %q: %v", path, err)
+	}
+	defer file.Close()
+
+	var templates []string
+	scanner := bufio.NewScanner(file)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if line == defaultHandshake {
+			templates = append(templates, "")
+			continue
+		}
+		if _, err := ParseHandshakeTemplate(line); err != nil {
+			return nil, fmt.Errorf("handshake alternates %q line %d: %v", path, lineNumber, err)
+		}
+		templates = append(templates, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading handshake alternates %q: %v", path, err)
+	}
+	return templates, nil
+}
+
+// renderHandshake executes a handshake template with the given fields.
+func renderHandshake(text string, fields handshakeFields) (string, error) {
+	tmpl, err := ParseHandshakeTemplate(text)
+	if err != nil {
+		return "", fmt.Errorf("invalid handshake template: %v", err)
+	}
+	tmpl.Funcs(template.FuncMap{"secret": func() string { return fields.Secret }})
+	var handshake strings.Builder
+	if err := tmpl.Execute(&handshake, fields); err != nil {
+		return "", fmt.Errorf("error executing handshake template: %v", err)
+	}
+	return handshake.String(), nil
+}
+
+// stringValue dereferences an optional string flag.
+func stringValue(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+// DescribeHandshake writes the handshake options would send, with the
+// secret masked, in the form describeHandshake shows it.
+func DescribeHandshake(w io.Writer, options Options) error {
+	handshake, err := buildHandshake(options, options.HandshakeTemplate())
+	if err != nil {
+		return err
+	}
+	describeHandshake(w, string(redactSecret([]byte(handshake), options.Secret())))
+	return nil
+}
+
+// describeHandshake writes an escaped and hex-dumped view of handshake,
+// showing which value ends up in each NUL-delimited rlogin field.
+func describeHandshake(w io.Writer, handshake string) {
+	fmt.Fprintf(w, "Handshake (%d bytes): %q\n\n", len(handshake), handshake)
+
+	// The handshake starts with a NUL, so the fields follow the first separator
+	fields := strings.Split(strings.TrimPrefix(handshake, "\x00"), "\x00")
+	labels := []string{"local username", "remote username", "terminal type"}
+	for i, label := range labels {
+		value := ""
+		if i < len(fields) {
+			value = fields[i]
+		}
+		fmt.Fprintf(w, "  %-16s %q\n", label+":", value)
+	}
+
+	fmt.Fprintf(w, "\n%s", hex.Dump([]byte(handshake)))
+}
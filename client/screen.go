@@ -0,0 +1,265 @@
+package client
+
+import (
+	"bufio"
+	"os"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Virtual screen parser states.
+const (
+	screenText = iota
+	screenEscape
+	screenCSI
+	screenOSC
+)
+
+// screenCapture renders terminal output into a virtual screen, following
+// just enough of ANSI (cursor movement and erasing) to reproduce what a BBS
+// draws. Every time the screen is cleared the previous contents are written
+// to the capture file as plain text, and Close writes the final screen.
+type screenCapture struct {
+	file   *os.File
+	writer *bufio.Writer
+
+	cols, rows int
+	cells      [][]rune
+	x, y       int
+	dirty      bool
+
+	state   int
+	params  []byte
+	pending []byte // incomplete UTF-8 sequence from the previous write
+}
+
+// newScreenCapture creates the capture file at path for a screen of the given size.
+func newScreenCapture(path string, cols, rows int) (*screenCapture, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &screenCapture{
+		file:   file,
+		writer: bufio.NewWriter(file),
+		cols:   cols,
+		rows:   rows,
+	}
+	c.cells = make([][]rune, rows)
+	for i := range c.cells {
+		c.cells[i] = blankLine(cols)
+	}
+	return c, nil
+}
+
+func blankLine(cols int) []rune {
+	line := make([]rune, cols)
+	for i := range line {
+		line[i] = ' '
+	}
+	return line
+}
+
+func (c *screenCapture) Write(p []byte) (int, error) {
+	data := append(c.pending, p...)
+	c.pending = nil
+
+	for len(data) > 0 {
+		if !utf8.FullRune(data) {
+			c.pending = append([]byte(nil), data...)
+			break
+		}
+		r, size := utf8.DecodeRune(data)
+		data = data[size:]
+		if err := c.feed(r); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// feed interprets a single rune of output.
+func (c *screenCapture) feed(r rune) error {
+	switch c.state {
+	case screenEscape:
+		switch r {
+		case '[':
+			c.state = screenCSI
+			c.params = c.params[:0]
+		case ']':
+			c.state = screenOSC
+		default:
+			c.state = screenText
+		}
+		return nil
+	case screenCSI:
+		if r >= 0x40 && r <= 0x7e {
+			c.state = screenText
+			return c.csi(r)
+		}
+		c.params = append(c.params, byte(r))
+		return nil
+	case screenOSC:
+		if r == 0x07 || r == 0x1b {
+			c.state = screenText
+		}
+		return nil
+	}
+
+	switch r {
+	case 0x1b:
+		c.state = screenEscape
+	case '\r':
+		c.x = 0
+	case '\n':
+		c.lineFeed()
+	case '\b':
+		if c.x > 0 {
+			c.x--
+		}
+	case '\t':
+		c.x = (c.x/8 + 1) * 8
+		if c.x >= c.cols {
+			c.x = c.cols - 1
+		}
+	default:
+		if r < 0x20 || r == 0x7f {
+			return nil
+		}
+		if c.x >= c.cols {
+			c.x = 0
+			c.lineFeed()
+		}
+		c.cells[c.y][c.x] = r
+		c.x++
+		c.dirty = true
+	}
+	return nil
+}
+
+// csi applies a complete CSI sequence ending in final.
+func (c *screenCapture) csi(final rune) error {
+	args := strings.Split(strings.TrimPrefix(string(c.params), "?"), ";")
+	arg := func(i, def int) int {
+		if i < len(args) {
+			if n, err := strconv.Atoi(args[i]); err == nil && n > 0 {
+				return n
+			}
+		}
+		return def
+	}
+	mode := 0
+	if len(args) > 0 {
+		mode, _ = strconv.Atoi(args[0])
+	}
+
+	switch final {
+	case 'H', 'f':
+		c.moveTo(arg(1, 1)-1, arg(0, 1)-1)
+	case 'A':
+		c.moveTo(c.x, c.y-arg(0, 1))
+	case 'B':
+		c.moveTo(c.x, c.y+arg(0, 1))
+	case 'C':
+		c.moveTo(c.x+arg(0, 1), c.y)
+	case 'D':
+		c.moveTo(c.x-arg(0, 1), c.y)
+	case 'J':
+		if mode == 2 {
+			// A full clear starts a new screen, so keep the old one
+			if err := c.snapshot(); err != nil {
+				return err
+			}
+			c.clear(0, c.rows)
+			c.x, c.y = 0, 0
+			return nil
+		}
+		c.eraseLine(mode)
+		if mode == 0 {
+			c.clear(c.y+1, c.rows)
+		} else if mode == 1 {
+			c.clear(0, c.y)
+		}
+	case 'K':
+		c.eraseLine(mode)
+	}
+	return nil
+}
+
+func (c *screenCapture) moveTo(x, y int) {
+	c.x = clamp(x, 0, c.cols-1)
+	c.y = clamp(y, 0, c.rows-1)
+}
+
+func clamp(v, low, high int) int {
+	if v < low {
+		return low
+	}
+	if v > high {
+		return high
+	}
+	return v
+}
+
+// lineFeed moves down a line, scrolling the screen up at the bottom.
+func (c *screenCapture) lineFeed() {
+	if c.y < c.rows-1 {
+		c.y++
+		return
+	}
+	copy(c.cells, c.cells[1:])
+	c.cells[c.rows-1] = blankLine(c.cols)
+}
+
+// eraseLine clears the current line from the cursor (mode 0), up to the
+// cursor (mode 1) or entirely (mode 2).
+func (c *screenCapture) eraseLine(mode int) {
+	from, to := c.x, c.cols
+	switch mode {
+	case 1:
+		from, to = 0, c.x+1
+	case 2:
+		from = 0
+	}
+	for i := from; i < to && i < c.cols; i++ {
+		c.cells[c.y][i] = ' '
+	}
+}
+
+// clear blanks the rows from first up to, but not including, last.
+func (c *screenCapture) clear(first, last int) {
+	for i := first; i < last; i++ {
+		c.cells[i] = blankLine(c.cols)
+	}
+}
+
+// snapshot writes the screen as plain text, trailing blanks trimmed, with
+// a form feed separating it from the previous one.
+func (c *screenCapture) snapshot() error {
+	if !c.dirty {
+		return nil
+	}
+
+	lines := make([]string, c.rows)
+	for i, line := range c.cells {
+		lines[i] = strings.TrimRight(string(line), " ")
+	}
+	text := strings.TrimRight(strings.Join(lines, "\n"), "\n")
+
+	if _, err := c.writer.WriteString(text + "\n\f\n"); err != nil {
+		return err
+	}
+	c.dirty = false
+	return c.writer.Flush()
+}
+
+// Close writes the final screen and closes the file.
+func (c *screenCapture) Close() error {
+	if err := c.snapshot(); err != nil {
+		c.file.Close()
+		return err
+	}
+	return c.file.Close()
+}
@@ -0,0 +1,199 @@
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// errExpectTimeout is returned when the expected text doesn't arrive in time.
+var errExpectTimeout = errors.New("timed out waiting for expected text")
+
+// scriptStep is a single line of a login script.
+type scriptStep struct {
+	action string // "send" or "expect"
+	text   string
+}
+
+// loadScript parses a login script. Each non-blank line is either
+// "send: <text>" or "expect: <substring>"; lines starting with # are comments.
+// {{secret}} in send: text is replaced with the secret when the line runs.
+func loadScript(path string) ([]scriptStep, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening script %q: %v", path, err)
+	}
+	defer file.Close()
+
+	var steps []scriptStep
+	scanner := bufio.NewScanner(file)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		parts := strings.SplitN(line, ":", 2)
+		action := strings.TrimSpace(parts[0])
+		if len(parts) != 2 || (action != "send" && action != "expect") {
+			return nil, fmt.Errorf("script %q line %d: expected \"send: <text>\" or \"expect: <text>\"", path, lineNumber)
+		}
+
+		text, err := DecodeEscapes(strings.TrimPrefix(parts[1], " "))
+		if err != nil {
+			return nil, fmt.Errorf("script %q line %d: %v", path, lineNumber, err)
+		}
+		steps = append(steps, scriptStep{action: action, text: text})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading script %q: %v", path, err)
+	}
+	return steps, nil
+}
+
+// DecodeEscapes expands \r, \n, \t, \\ and \xHH escapes in s.
+func DecodeEscapes(s string) (string, error) {
+	var out strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i == len(s)-1 {
+			out.WriteByte(s[i])
+			continue
+		}
+		i++
+		switch s[i] {
+		case 'r':
+			out.WriteByte('\r')
+		case 'n':
+			out.WriteByte('\n')
+		case 't':
+			out.WriteByte('\t')
+		case '\\':
+			out.WriteByte('\\')
+		case 'x':
+			if i+2 >= len(s) {
+				return "", fmt.Errorf("incomplete \\x escape")
+			}
+			value, err := strconv.ParseUint(s[i+1:i+3], 16, 8)
+			if err != nil {
+				return "", fmt.Errorf("invalid \\x escape %q", s[i-1:i+3])
+			}
+			out.WriteByte(byte(value))
+			i += 2
+		default:
+			out.WriteByte('\\')
+			out.WriteByte(s[i])
+		}
+	}
+	return out.String(), nil
+}
+
+// runScript performs the login script against the connection. Server output
+// received while waiting is written to outputData as usual.
+func (t *TelnetClient) runScript(connection net.Conn, reader *serverReader, outputData io.Writer, stats *SessionStats) error {
+	for _, step := range t.script {
+		switch step.action {
+		case "send":
+			n, err := t.typeOut(connection, []byte(strings.ReplaceAll(step.text, scriptSecret, t.secret)))
+			stats.BytesSent += int64(n)
+			t.metrics.addSent(n)
+			if err != nil {
+				return fmt.Errorf("script send failed: %v", err)
+			}
+		case "expect":
+			pattern := step.text
+			match := func(data []byte) bool { return strings.Contains(string(data), pattern) }
+			if _, err := t.expect(connection, reader, match, t.scriptTimeout, outputData, stats); err != nil {
+				return fmt.Errorf("script expect %q: %v", pattern, err)
+			}
+		}
+	}
+	return nil
+}
+
+// typeOut writes automated input to w one character at a time, -type-delay
+// apart, so it arrives at the pace of someone typing. With no delay it is
+// written in one go. The secret and password are masked in the text as a
+// whole, since -debug and -trace-file would only see one character of
+// them at a time.
+func (t *TelnetClient) typeOut(w io.Writer, text []byte) (int, error) {
+	shown := t.redactSent(text)
+	if t.typeDelay <= 0 {
+		return writeMasked(w, text, shown)
+	}
+
+	written := 0
+	for len(text) > 0 {
+		if written > 0 {
+			time.Sleep(t.typeDelay)
+		}
+		_, size := utf8.DecodeRune(text)
+		n, err := writeMasked(w, text[:size], shown[:size])
+		written += n
+		if err != nil {
+			return written, err
+		}
+		text, shown = text[size:], shown[size:]
+	}
+	return written, nil
+}
+
+// Expect reads from conn until pattern appears in the server output or
+// timeout elapses, and returns everything read. Telnet negotiation is
+// stripped and answered just as it is during a normal session.
+func (t *TelnetClient) Expect(conn net.Conn, pattern string, timeout time.Duration) ([]byte, error) {
+	reader := newServerReader(conn, t.newNegotiator(conn), t.bufferSize)
+	match := func(data []byte) bool { return bytes.Contains(data, []byte(pattern)) }
+	return t.expect(conn, reader, match, timeout, nil, nil)
+}
+
+// ExpectRegexp is like Expect but waits for the output to match pattern.
+func (t *TelnetClient) ExpectRegexp(conn net.Conn, pattern *regexp.Regexp, timeout time.Duration) ([]byte, error) {
+	reader := newServerReader(conn, t.newNegotiator(conn), t.bufferSize)
+	return t.expect(conn, reader, pattern.Match, timeout, nil, nil)
+}
+
+// expect reads server payload until match reports true for everything read
+// so far, or timeout elapses. Payload is passed on to outputData as it arrives.
+func (t *TelnetClient) expect(connection net.Conn, reader *serverReader, match func([]byte) bool, timeout time.Duration, outputData io.Writer, stats *SessionStats) ([]byte, error) {
+	defer connection.SetReadDeadline(time.Time{})
+	if err := connection.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+		return nil, err
+	}
+
+	var received []byte
+	for {
+		payload, _, err := reader.ReadPayload()
+		if len(payload) > 0 {
+			received = append(received, payload...)
+			if outputData != nil {
+				if _, err := outputData.Write(payload); err != nil {
+					releasePayload(payload)
+					return received, fmt.Errorf("error writing output: %v", err)
+				}
+			}
+			if stats != nil {
+				stats.BytesReceived += int64(len(payload))
+				t.metrics.addReceived(len(payload))
+			}
+			releasePayload(payload)
+			if match(received) {
+				return received, nil
+			}
+		}
+		if err != nil {
+			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+				return received, errExpectTimeout
+			}
+			return received, err
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package client
+
+import (
+	"bufio"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeScript saves a login script to a temporary file and returns its path.
+func writeScript(t *testing.T, script string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "login.script")
+	if err := os.WriteFile(path, []byte(script), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// tracedSend returns everything a -trace-file recorded as sent.
+func tracedSend(t *testing.T, path string) string {
+	t.Helper()
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	var sent strings.Builder
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.SplitN(scanner.Text(), " ", 3)
+		if len(fields) != 3 || fields[1] != "SEND" {
+			continue
+		}
+		data, err := strconv.Unquote(fields[2])
+		if err != nil {
+			t.Fatalf("invalid trace line %q: %v", scanner.Text(), err)
+		}
+		sent.WriteString(data)
+	}
+	return sent.String()
+}
+
+// TestTypedSecretIsMasked checks that a secret typed out a character at a
+// time by -type-delay is still masked in the trace and debug output.
+func TestTypedSecretIsMasked(t *testing.T) {
+	const secret = "s3cret"
+	script := writeScript(t, "send: {{secret}}\\r\n")
+	client := newTestClient(t, NewOptions("127.0.0.1", 513, "sysop", "", WithLocalName("me"), WithScript(script), WithQuiet()))
+	client.secret = secret
+	client.typeDelay = time.Millisecond
+	client.traceFile = filepath.Join(t.TempDir(), "session.trace")
+	var debug syncBuffer
+	client.debugLog = log.New(&debug, "", 0)
+	conn := pipeServer(t, client)
+
+	input, _ := openInput(t)
+	done := startSession(client, input, io.Discard)
+	readHandshake(t, conn, len(testHandshake))
+
+	got := make([]byte, len(secret)+1)
+	conn.SetReadDeadline(time.Now().Add(testTimeout))
+	if _, err := io.ReadFull(conn, got); err != nil || string(got) != secret+"\r" {
+		t.Fatalf("server received %q, %v; want the secret", got, err)
+	}
+	conn.Close()
+	waitSession(t, done)
+
+	sent := tracedSend(t, client.traceFile)
+	if strings.Contains(sent, secret) || !strings.Contains(sent, "******\r") {
+		t.Errorf("trace recorded %q as sent, want the secret masked", sent)
+	}
+	for _, c := range secret {
+		if strings.Contains(debug.String(), "|"+string(c)+"|") {
+			t.Errorf("-debug dumped %q of the secret on its own", c)
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package client
+
+import (
+	"bytes"
+	"io"
+)
+
+// scriptSecret is replaced by the -secret-file or -prompt-secret value in
+// login script send: lines. The same {{secret}} works in a handshake
+// template, as does {{.Secret}}.
+const scriptSecret = "{{secret}}"
+
+// redactSecret masks every occurrence of each secret in data with asterisks
+// of the same length, so dumps keep their layout.
+func redactSecret(data []byte, secrets ...string) []byte {
+	for _, secret := range secrets {
+		if secret == "" || !bytes.Contains(data, []byte(secret)) {
+			continue
+		}
+		data = bytes.ReplaceAll(data, []byte(secret), bytes.Repeat([]byte("*"), len(secret)))
+	}
+	return data
+}
+
+// maskedWriter is implemented by the connection wrappers that keep a copy
+// of what is sent, for -debug and -trace-file. WriteMasked sends p but puts
+// shown, of the same length, in the copy, so text that is sent a piece at
+// a time can still be masked as a whole.
+type maskedWriter interface {
+	WriteMasked(p, shown []byte) (int, error)
+}
+
+// writeMasked writes p to w, which shows shown in its place if it keeps a copy.
+func writeMasked(w io.Writer, p, shown []byte) (int, error) {
+	if m, ok := w.(maskedWriter); ok {
+		return m.WriteMasked(p, shown)
+	}
+	return w.Write(p)
+}
+
+// redactSent masks the secret and the password in bytes sent to the server,
+// for copies of the session kept on disk.
+func (t *TelnetClient) redactSent(p []byte) []byte {
+	return redactSecret(p, t.secret, stringValue(t.options.Pass()))
+}
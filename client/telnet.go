@@ -0,0 +1,376 @@
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"sync"
+)
+
+// Telnet command bytes (RFC 854).
+const (
+	telnetSE   byte = 240
+	telnetSB   byte = 250
+	telnetWILL byte = 251
+	telnetWONT byte = 252
+	telnetDO   byte = 253
+	telnetDONT byte = 254
+	telnetIAC  byte = 255
+)
+
+// Telnet options and subnegotiation codes handled by the client.
+const (
+	optionECHO  byte = 1
+	optionTTYPE byte = 24
+	optionNAWS  byte = 31
+
+	// optionCOMPRESS2 is MCCP version 2 (Mud Client Compression Protocol)
+	optionCOMPRESS2 byte = 86
+
+	ttypeIS   byte = 0
+	ttypeSEND byte = 1
+)
+
+// Parser states used while walking the server byte stream.
+const (
+	stateData = iota
+	stateIAC
+	stateOption
+	stateSB
+	stateSBIAC
+)
+
+// maxSubnegotiation bounds how much of an SB...SE block the parser keeps.
+// The options the client handles need a few bytes; a longer block is
+// dropped, so a broken or hostile server can't grow it without end.
+const maxSubnegotiation = 4096
+
+// telnetParser strips telnet IAC sequences from the server stream. It keeps
+// its state between calls so sequences split across reads are handled.
+type telnetParser struct {
+	state          int
+	command        byte
+	subnegotiation []byte
+	oversized      bool
+
+	// OnCommand is called for each WILL/WONT/DO/DONT sequence received.
+	OnCommand func(command, option byte)
+	// OnSubnegotiation is called with the option and payload of each SB...SE block.
+	OnSubnegotiation func(option byte, data []byte)
+}
+
+// Strip removes IAC command sequences from data and returns the application
+// payload, appended to dst. If the server starts MCCP2 compression part way
+// through data, Strip stops there and returns the remaining, compressed,
+// bytes as rest.
+func (p *telnetParser) Strip(dst, data []byte) (payload, rest []byte) {
+	payload = dst
+	for i, b := range data {
+		switch p.state {
+		case stateData:
+			if b == telnetIAC {
+				p.state = stateIAC
+				continue
+			}
+			payload = append(payload, b)
+		case stateIAC:
+			switch b {
+			case telnetIAC:
+				// Escaped 0xFF data byte
+				payload = append(payload, b)
+				p.state = stateData
+			case telnetWILL, telnetWONT, telnetDO, telnetDONT:
+				p.command = b
+				p.state = stateOption
+			case telnetSB:
+				p.subnegotiation = p.subnegotiation[:0]
+				p.oversized = false
+				p.state = stateSB
+			default:
+				// Two-byte command (NOP, GA, etc.)
+				p.state = stateData
+			}
+		case stateOption:
+			p.state = stateData
+			if p.OnCommand != nil {
+				p.OnCommand(p.command, b)
+			}
+		case stateSB:
+			if b == telnetIAC {
+				p.state = stateSBIAC
+				continue
+			}
+			p.addSubnegotiation(b)
+		case stateSBIAC:
+			switch b {
+			case telnetSE:
+				p.state = stateData
+				if p.oversized {
+					continue
+				}
+				if p.OnSubnegotiation != nil && len(p.subnegotiation) > 0 {
+					p.OnSubnegotiation(p.subnegotiation[0], p.subnegotiation[1:])
+				}
+				if len(p.subnegotiation) > 0 && p.subnegotiation[0] == optionCOMPRESS2 {
+					return payload, data[i+1:]
+				}
+			case telnetIAC:
+				// Escaped 0xFF inside the subnegotiation payload
+				p.addSubnegotiation(b)
+				p.state = stateSB
+			default:
+				p.state = stateSB
+			}
+		}
+	}
+
+	return payload, nil
+}
+
+// addSubnegotiation collects b as part of the current SB block, unless the
+// block has grown past maxSubnegotiation.
+func (p *telnetParser) addSubnegotiation(b byte) {
+	if len(p.subnegotiation) >= maxSubnegotiation {
+		p.oversized = true
+		return
+	}
+	p.subnegotiation = append(p.subnegotiation, b)
+}
+
+// telnetNegotiator answers the option negotiation requests the client supports.
+type telnetNegotiator struct {
+	writer     io.Writer
+	termType   string
+	windowSize func() (cols, rows int)
+	noCompress bool
+	trace      *log.Logger // logs each negotiation step when set (-debug)
+
+	mu        sync.Mutex
+	naws      bool
+	localEcho bool // the server said WONT ECHO, so typing must be echoed here
+	echoKnown bool // the server has negotiated ECHO at all
+}
+
+// HandleCommand replies to WILL/WONT/DO/DONT requests from the server.
+func (n *telnetNegotiator) HandleCommand(command, option byte) {
+	n.tracef("Server sent %s %s", commandName(command), optionName(option))
+
+	switch {
+	case command == telnetDO && option == optionTTYPE:
+		n.sendCommand(telnetWILL, optionTTYPE)
+	case command == telnetDO && option == optionNAWS:
+		n.mu.Lock()
+		n.naws = true
+		n.mu.Unlock()
+		n.sendCommand(telnetWILL, optionNAWS)
+		n.SendWindowSize()
+	case command == telnetDONT && option == optionNAWS:
+		n.mu.Lock()
+		n.naws = false
+		n.mu.Unlock()
+	case command == telnetWILL && option == optionECHO:
+		if n.setLocalEcho(false) {
+			n.sendCommand(telnetDO, optionECHO)
+		}
+	case command == telnetWONT && option == optionECHO:
+		if n.setLocalEcho(true) {
+			n.sendCommand(telnetDONT, optionECHO)
+		}
+	case command == telnetWILL && option == optionCOMPRESS2:
+		if n.noCompress {
+			n.sendCommand(telnetDONT, optionCOMPRESS2)
+		} else {
+			n.sendCommand(telnetDO, optionCOMPRESS2)
+		}
+	}
+}
+
+// LocalEcho reports whether the server has asked the client to echo typed
+// characters itself.
+func (n *telnetNegotiator) LocalEcho() bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.localEcho
+}
+
+// setLocalEcho records the server's ECHO choice. It reports whether this
+// is news that needs acknowledging, so repeated requests don't start a
+// negotiation loop.
+func (n *telnetNegotiator) setLocalEcho(local bool) bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	changed := !n.echoKnown || n.localEcho != local
+	n.localEcho = local
+	n.echoKnown = true
+	return changed
+}
+
+// SendWindowSize reports the current terminal dimensions if the server enabled NAWS.
+func (n *telnetNegotiator) SendWindowSize() {
+	n.mu.Lock()
+	enabled := n.naws
+	n.mu.Unlock()
+	if !enabled || n.windowSize == nil {
+		return
+	}
+
+	cols, rows := n.windowSize()
+	reply := []byte{telnetIAC, telnetSB, optionNAWS}
+	for _, b := range []byte{byte(cols >> 8), byte(cols), byte(rows >> 8), byte(rows)} {
+		reply = append(reply, b)
+		if b == telnetIAC {
+			// 0xFF must be doubled inside a subnegotiation
+			reply = append(reply, b)
+		}
+	}
+	reply = append(reply, telnetIAC, telnetSE)
+	n.tracef("Sent NAWS %dx%d", cols, rows)
+	n.send(reply...)
+}
+
+// HandleSubnegotiation replies to SB requests from the server.
+func (n *telnetNegotiator) HandleSubnegotiation(option byte, data []byte) {
+	n.tracef("Server sent SB %s % x", optionName(option), data)
+
+	switch {
+	case option == optionTTYPE && len(data) > 0 && data[0] == ttypeSEND:
+		reply := []byte{telnetIAC, telnetSB, optionTTYPE, ttypeIS}
+		reply = append(reply, n.termType...)
+		reply = append(reply, telnetIAC, telnetSE)
+		n.tracef("Sent TTYPE IS %q", n.termType)
+		n.send(reply...)
+	}
+}
+
+func (n *telnetNegotiator) sendCommand(command, option byte) {
+	n.tracef("Sent %s %s", commandName(command), optionName(option))
+	n.send(telnetIAC, command, option)
+}
+
+func (n *telnetNegotiator) send(data ...byte) {
+	n.writer.Write(data)
+}
+
+func (n *telnetNegotiator) tracef(format string, v ...interface{}) {
+	if n.trace != nil {
+		n.trace.Printf(format, v...)
+	}
+}
+
+// commandName returns the RFC 854 name of a negotiation command.
+func commandName(command byte) string {
+	switch command {
+	case telnetWILL:
+		return "WILL"
+	case telnetWONT:
+		return "WONT"
+	case telnetDO:
+		return "DO"
+	case telnetDONT:
+		return "DONT"
+	}
+	return fmt.Sprintf("command %d", command)
+}
+
+// optionName returns the name of a telnet option, or its number if the
+// client doesn't know it.
+func optionName(option byte) string {
+	switch option {
+	case optionECHO:
+		return "ECHO"
+	case optionTTYPE:
+		return "TTYPE"
+	case optionNAWS:
+		return "NAWS"
+	case optionCOMPRESS2:
+		return "COMPRESS2"
+	}
+	return fmt.Sprintf("option %d", option)
+}
+
+// payloadPool recycles the payload buffers returned by ReadPayload, so a
+// busy connection doesn't allocate a fresh buffer for every read.
+var payloadPool = sync.Pool{
+	New: func() any { return new([]byte) },
+}
+
+// releasePayload returns a payload from ReadPayload to the pool. The caller
+// must not use it afterwards.
+func releasePayload(payload []byte) {
+	payload = payload[:0]
+	payloadPool.Put(&payload)
+}
+
+// serverReader reads from the server connection, answering telnet
+// negotiation as it goes and returning only the application payload.
+// Once the server starts MCCP2 compression, reads go through a zlib
+// decompressor until the compressed stream ends.
+type serverReader struct {
+	raw    io.Reader // uncompressed byte stream from the server
+	source io.Reader // raw, or a decompressor reading from it
+	parser *telnetParser
+	buffer []byte
+}
+
+func newServerReader(connection net.Conn, negotiator *telnetNegotiator, bufferSize int) *serverReader {
+	return &serverReader{
+		raw:    connection,
+		source: connection,
+		parser: &telnetParser{
+			OnCommand:        negotiator.HandleCommand,
+			OnSubnegotiation: negotiator.HandleSubnegotiation,
+		},
+		buffer: make([]byte, bufferSize),
+	}
+}
+
+// Prepend queues data, already read from the connection, to be returned
+// ahead of anything read next.
+func (r *serverReader) Prepend(data []byte) {
+	r.raw = io.MultiReader(bytes.NewReader(append([]byte(nil), data...)), r.raw)
+	r.source = r.raw
+}
+
+// ReadPayload performs a single read from the connection. It returns the
+// payload left after stripping telnet commands, which may be empty, and
+// whether the read filled the whole buffer. The payload is not shared with
+// the read buffer, and can be handed back with releasePayload once used.
+func (r *serverReader) ReadPayload() ([]byte, bool, error) {
+	n, err := r.source.Read(r.buffer)
+	if err == io.EOF && r.source != r.raw {
+		// The compressed stream ended; the server carries on uncompressed
+		r.source = r.raw
+		err = nil
+	}
+	if n == 0 {
+		return nil, false, err
+	}
+
+	payload, rest := r.parser.Strip((*payloadPool.Get().(*[]byte))[:0], r.buffer[:n])
+	if rest != nil {
+		if zerr := r.startDecompression(rest); zerr != nil && err == nil {
+			err = zerr
+		}
+	}
+	return payload, n == len(r.buffer), err
+}
+
+// startDecompression routes further reads through zlib, starting with the
+// already-read bytes that followed IAC SB COMPRESS2 IAC SE.
+func (r *serverReader) startDecompression(rest []byte) error {
+	// A bufio.Reader is an io.ByteReader, so zlib reads no further than the
+	// end of the compressed stream and leaves what follows in raw.
+	raw := bufio.NewReader(io.MultiReader(bytes.NewReader(append([]byte(nil), rest...)), r.raw))
+	r.raw = raw
+
+	decompressor, err := zlib.NewReader(raw)
+	if err != nil {
+		return fmt.Errorf("failed to start MCCP decompression: %v", err)
+	}
+	r.source = decompressor
+	return nil
+}
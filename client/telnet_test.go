@@ -0,0 +1,66 @@
+package client
+
+import (
+	"bytes"
+	"testing"
+)
+
+// benchmarkScreen returns about size bytes of typical board output: ANSI
+// colors, CP437 box drawing and text, with the odd telnet command mixed in
+// if withIAC is set.
+func benchmarkScreen(size int, withIAC bool) []byte {
+	line := []byte("\x1b[1;33m\xc9\xcd\xcd\xcd\xbb \x1b[0;36mWelcome to the board\x1b[0m \xb0\xb1\xb2\xdb\r\n")
+	var screen bytes.Buffer
+	for screen.Len() < size {
+		screen.Write(line)
+		if withIAC {
+			screen.Write([]byte{telnetIAC, telnetDO, optionNAWS})
+		}
+	}
+	return screen.Bytes()
+}
+
+// TestOversizedSubnegotiation checks that an SB block longer than
+// maxSubnegotiation is dropped without being kept whole, and that the
+// parser carries on with the next block and the data after it.
+func TestOversizedSubnegotiation(t *testing.T) {
+	var blocks [][]byte
+	parser := &telnetParser{OnSubnegotiation: func(option byte, data []byte) {
+		blocks = append(blocks, append([]byte{option}, data...))
+	}}
+
+	data := []byte{telnetIAC, telnetSB, optionTTYPE}
+	data = append(data, bytes.Repeat([]byte{'x'}, 2*maxSubnegotiation)...)
+	data = append(data, telnetIAC, telnetSE)
+	data = append(data, "Welcome"...)
+	data = append(data, telnetIAC, telnetSB, optionTTYPE, ttypeSEND, telnetIAC, telnetSE)
+
+	var payload []byte
+	for len(data) > 0 {
+		chunk := data[:min(1000, len(data))]
+		data = data[len(chunk):]
+		payload, _ = parser.Strip(payload, chunk)
+		if len(parser.subnegotiation) > maxSubnegotiation {
+			t.Fatalf("parser kept %d bytes of subnegotiation, over %d", len(parser.subnegotiation), maxSubnegotiation)
+		}
+	}
+
+	if string(payload) != "Welcome" {
+		t.Errorf("payload = %q, want %q", payload, "Welcome")
+	}
+	if len(blocks) != 1 || !bytes.Equal(blocks[0], []byte{optionTTYPE, ttypeSEND}) {
+		t.Errorf("subnegotiations = %q, want just the TTYPE SEND", blocks)
+	}
+}
+
+func BenchmarkTelnetParserStrip(b *testing.B) {
+	data := benchmarkScreen(64<<10, true)
+	parser := &telnetParser{OnCommand: func(command, option byte) {}}
+	dst := make([]byte, 0, len(data))
+
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		parser.Strip(dst[:0], data)
+	}
+}
@@ -0,0 +1,61 @@
+package client
+
+import (
+	"io"
+	"time"
+)
+
+// bitsPerByte is the cost of one byte on an 8N1 serial line: a start bit,
+// eight data bits and a stop bit.
+const bitsPerByte = 10
+
+// throttledWriter limits writes to the rate of a modem of the given baud,
+// sleeping between small slices so output trickles out like a real line.
+type throttledWriter struct {
+	writer      io.Writer
+	byteDelay   time.Duration
+	sliceLength int
+	next        time.Time
+}
+
+func newThrottledWriter(w io.Writer, baud int) *throttledWriter {
+	bytesPerSecond := baud / bitsPerByte
+	if bytesPerSecond < 1 {
+		bytesPerSecond = 1
+	}
+	// Write roughly 100 slices a second to keep the output smooth
+	sliceLength := bytesPerSecond / 100
+	if sliceLength < 1 {
+		sliceLength = 1
+	}
+	return &throttledWriter{
+		writer:      w,
+		byteDelay:   time.Second / time.Duration(bytesPerSecond),
+		sliceLength: sliceLength,
+	}
+}
+
+// Write passes p through at the emulated line rate.
+func (w *throttledWriter) Write(p []byte) (int, error) {
+	// Idle time doesn't earn credit for a later burst
+	if now := time.Now(); w.next.Before(now) {
+		w.next = now
+	}
+
+	written := 0
+	for written < len(p) {
+		end := written + w.sliceLength
+		if end > len(p) {
+			end = len(p)
+		}
+
+		time.Sleep(time.Until(w.next))
+		n, err := w.writer.Write(p[written:end])
+		written += n
+		w.next = w.next.Add(time.Duration(n) * w.byteDelay)
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
@@ -0,0 +1,79 @@
+package client
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// traceFile records the exact bytes sent and received, one chunk per line:
+//
+//	2024-05-01T12:00:00.123456789Z RECV "\x00Welcome\r\n"
+//
+// Each chunk is a Go quoted string, so the file can be diffed as text and
+// strconv.Unquote recovers the original bytes. Lines starting with # mark
+// the start and end of each connection.
+type traceFile struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+// openTraceFile opens path for appending and marks the start of a connection.
+func openTraceFile(path, address string) (*traceFile, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := fmt.Fprintf(file, "# connected to %s at %s\n", address, time.Now().Format(time.RFC3339Nano)); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &traceFile{file: file}, nil
+}
+
+// record appends one chunk; direction is SEND or RECV.
+func (f *traceFile) record(direction string, p []byte) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	fmt.Fprintf(f.file, "%s %s %q\n", time.Now().Format(time.RFC3339Nano), direction, p)
+}
+
+// Close marks the end of the connection and closes the file.
+func (f *traceFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	fmt.Fprintf(f.file, "# closed at %s\n", time.Now().Format(time.RFC3339Nano))
+	return f.file.Close()
+}
+
+// traceConn wraps a connection and records everything read from or
+// written to it in a traceFile, before any other processing. What is sent
+// passes through redact first, so the password and secret in the
+// handshake never reach the file.
+type traceConn struct {
+	net.Conn
+	trace  *traceFile
+	redact func([]byte) []byte
+}
+
+func (c *traceConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if n > 0 {
+		c.trace.record("RECV", p[:n])
+	}
+	return n, err
+}
+
+func (c *traceConn) Write(p []byte) (int, error) {
+	return c.WriteMasked(p, c.redact(p))
+}
+
+func (c *traceConn) WriteMasked(p, shown []byte) (int, error) {
+	n, err := writeMasked(c.Conn, p, shown)
+	if n > 0 {
+		c.trace.record("SEND", shown[:n])
+	}
+	return n, err
+}
@@ -0,0 +1,128 @@
+package client
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// transcriptTimeFormat stamps each line of a -log-file transcript.
+const transcriptTimeFormat = "2006-01-02 15:04:05"
+
+// sessionLogTimeFormat starts the name of each -session-log-dir file, so
+// sorting the names sorts the sessions by start time.
+const sessionLogTimeFormat = "20060102-150405"
+
+// transcriptWriter appends a human-readable transcript of the session to a
+// file: escape sequences are removed by the caller, carriage returns are
+// dropped and every line starts with the time it was received.
+type transcriptWriter struct {
+	file        *os.File
+	writer      *bufio.Writer
+	atLineStart bool
+}
+
+// newTranscriptWriter opens path for appending and writes a session header.
+func newTranscriptWriter(path, address string) (*transcriptWriter, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &transcriptWriter{
+		file:        file,
+		writer:      bufio.NewWriter(file),
+		atLineStart: true,
+	}
+	fmt.Fprintf(w.writer, "=== Session with %s started %s ===\n", address, time.Now().Format(transcriptTimeFormat))
+	if err := w.writer.Flush(); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *transcriptWriter) Write(p []byte) (int, error) {
+	for _, b := range p {
+		if b == '\r' {
+			continue
+		}
+		if w.atLineStart {
+			fmt.Fprintf(w.writer, "[%s] ", time.Now().Format(transcriptTimeFormat))
+			w.atLineStart = false
+		}
+		w.writer.WriteByte(b)
+		if b == '\n' {
+			w.atLineStart = true
+		}
+	}
+	// Flush every chunk so the transcript is complete even after a crash
+	if err := w.writer.Flush(); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Close ends any unfinished line, writes a footer and closes the file.
+func (w *transcriptWriter) Close() error {
+	if !w.atLineStart {
+		w.writer.WriteByte('\n')
+	}
+	fmt.Fprintf(w.writer, "=== Session ended %s ===\n", time.Now().Format(transcriptTimeFormat))
+	if err := w.writer.Flush(); err != nil {
+		w.file.Close()
+		return err
+	}
+	return w.file.Close()
+}
+
+// openSessionLog starts a transcript for a new session with address in
+// dir, named after the start time and host, then removes the oldest
+// transcripts so no more than keep remain (0 keeps them all).
+func openSessionLog(dir, address string, keep int) (*transcriptWriter, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+
+	host := strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '.' || r == '-' {
+			return r
+		}
+		return '_'
+	}, address)
+	name := time.Now().Format(sessionLogTimeFormat) + "-" + host + ".log"
+
+	w, err := newTranscriptWriter(filepath.Join(dir, name), address)
+	if err != nil {
+		return nil, err
+	}
+	if keep > 0 {
+		if err := pruneSessionLogs(dir, keep); err != nil {
+			w.Close()
+			return nil, err
+		}
+	}
+	return w, nil
+}
+
+// pruneSessionLogs deletes all but the newest keep session transcripts in dir.
+func pruneSessionLogs(dir string, keep int) error {
+	names, err := filepath.Glob(filepath.Join(dir, "[0-9]*-*.log"))
+	if err != nil {
+		return err
+	}
+	if len(names) <= keep {
+		return nil
+	}
+	sort.Strings(names)
+	for _, name := range names[:len(names)-keep] {
+		if err := os.Remove(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,46 @@
+package client
+
+import (
+	"io"
+	"unicode/utf8"
+)
+
+// utf8Writer passes UTF-8 output through untranslated, only making sure
+// each write ends on a character boundary: a multi-byte character split
+// across reads is held back until the rest arrives, so the terminal and
+// the recorders never see half of one.
+type utf8Writer struct {
+	writer  io.Writer
+	pending []byte
+}
+
+func newUTF8Writer(w io.Writer) *utf8Writer {
+	return &utf8Writer{writer: w}
+}
+
+func (w *utf8Writer) Write(p []byte) (int, error) {
+	data := p
+	if len(w.pending) > 0 {
+		data = append(w.pending, p...)
+		w.pending = nil
+	}
+
+	// Only the last few bytes can start an unfinished character
+	for i := len(data) - 1; i >= 0 && i >= len(data)-utf8.UTFMax+1; i-- {
+		if !utf8.RuneStart(data[i]) {
+			continue
+		}
+		if data[i] >= utf8.RuneSelf && !utf8.FullRune(data[i:]) {
+			w.pending = append([]byte(nil), data[i:]...)
+			data = data[:i]
+		}
+		break
+	}
+
+	if len(data) > 0 {
+		if _, err := w.writer.Write(data); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
@@ -0,0 +1,19 @@
+package client
+
+import (
+	"io"
+	"testing"
+)
+
+func BenchmarkUTF8Writer(b *testing.B) {
+	// Split a character across every write, as a busy server would
+	data := []byte("╔═══╗ Welcome to the board ░▒▓█ ")
+	data = append(data, data[:len(data)-2]...)
+	w := newUTF8Writer(io.Discard)
+
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		w.Write(data)
+	}
+}
@@ -0,0 +1,97 @@
+package client
+
+import (
+	"io"
+	"unicode/utf8"
+)
+
+// lineWrapper soft-wraps plain-text output at a fixed column, moving the
+// word that overflows onto a new line where it can and breaking it where
+// it can't. Columns are counted in characters rather than bytes, so the
+// CP437 box-drawing characters, several bytes each in UTF-8, take one
+// column like they do on screen. Words are only moved within a write, so
+// output is never held back from the terminal.
+type lineWrapper struct {
+	writer  io.Writer
+	width   int
+	col     int
+	pending []byte // incomplete UTF-8 sequence from the previous write
+}
+
+func newLineWrapper(w io.Writer, width int) *lineWrapper {
+	return &lineWrapper{writer: w, width: width}
+}
+
+func (w *lineWrapper) Write(p []byte) (int, error) {
+	data := p
+	if len(w.pending) > 0 {
+		data = append(w.pending, p...)
+		w.pending = nil
+	}
+
+	out := make([]byte, 0, len(data)+len(data)/w.width*2+2)
+	word := -1   // offset in out of the word being written, if any
+	wordCol := 0 // the column it started in
+	for len(data) > 0 {
+		if !utf8.FullRune(data) {
+			w.pending = append([]byte(nil), data...)
+			break
+		}
+		r, size := utf8.DecodeRune(data)
+		char := data[:size]
+		data = data[size:]
+
+		switch {
+		case r == '\r' || r == '\n':
+			out = append(out, char...)
+			w.col = 0
+			word = -1
+			continue
+		case r == ' ' || r == '\t':
+			word = -1
+			if w.col >= w.width {
+				// The space at the end of a full line becomes the break
+				out = append(out, '\r', '\n')
+				w.col = 0
+				continue
+			}
+			out = append(out, char...)
+			if r == '\t' {
+				w.col = min((w.col/8+1)*8, w.width)
+			} else {
+				w.col++
+			}
+			continue
+		case r < 0x20:
+			// Other control characters take no room
+			out = append(out, char...)
+			continue
+		}
+
+		if w.col >= w.width {
+			if word >= 0 && wordCol > 0 {
+				// Move the whole word down to the new line
+				out = append(out[:word], append([]byte("\r\n"), out[word:]...)...)
+				word += 2
+				w.col -= wordCol
+				wordCol = 0
+			} else {
+				out = append(out, '\r', '\n')
+				w.col = 0
+				word = -1
+			}
+		}
+		if word < 0 {
+			word, wordCol = len(out), w.col
+		}
+		out = append(out, char...)
+		w.col++
+	}
+
+	if len(out) > 0 {
+		if _, err := w.writer.Write(out); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
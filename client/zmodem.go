@@ -0,0 +1,279 @@
+package client
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sync/atomic"
+)
+
+// Zmodem markers looked for in the server stream. A download starts with
+// the sender's ZRQINIT hex header and ends when the sender says "over and
+// out" after the ZFIN exchange. An upload starts with the receiver's ZRINIT
+// and, as far as the server is concerned, ends with its ZFIN. Either side
+// can cancel with CANs.
+var (
+	zmodemStart  = []byte("**\x18B00")
+	zmodemUpload = []byte("**\x18B01")
+	zmodemFinish = []byte("**\x18B08")
+	zmodemOver   = []byte("OO")
+	zmodemCancel = []byte("\x18\x18\x18\x18\x18")
+)
+
+// zmodemGuard sits in front of the output translation chain. While a
+// Zmodem transfer is running it writes the server bytes straight to the
+// terminal, bypassing CP437 translation, ANSI stripping, throttling and
+// the recorders, so the terminal's own Zmodem support receives them intact.
+// With a helper configured, transfers go to an external rz or sz instead.
+type zmodemGuard struct {
+	translated io.Writer // the normal output chain
+	terminal   io.Writer // the terminal, with no translation
+
+	helper   *zmodemHelper   // nil to pass transfers to the terminal
+	transfer *zmodemTransfer // the running helper, if any
+	errorf   func(format string, v ...interface{})
+
+	active    atomic.Bool // read by the input goroutine
+	upload    bool        // the server is receiving
+	finishing bool        // ZFIN seen, waiting for "OO"
+	tail      []byte      // end of the previous write, for split end markers
+	pending   []byte      // possible start of a start marker, held back
+}
+
+func newZmodemGuard(translated, terminal io.Writer) *zmodemGuard {
+	return &zmodemGuard{translated: translated, terminal: terminal}
+}
+
+// Helping reports whether an external helper is running the transfer, in
+// which case keyboard input must be held off.
+func (g *zmodemGuard) Helping() bool {
+	return g.Active() && g.transfer != nil
+}
+
+// Active reports whether a transfer is in progress. A nil guard (detection
+// disabled) is never active.
+func (g *zmodemGuard) Active() bool {
+	return g != nil && g.active.Load()
+}
+
+func (g *zmodemGuard) Write(p []byte) (int, error) {
+	data := p
+	if len(g.pending) > 0 {
+		data = append(g.pending, p...)
+		g.pending = nil
+	}
+
+	for len(data) > 0 {
+		if g.Active() {
+			end := g.transferEnd(data)
+			if end < 0 {
+				g.keepTail(data)
+				return len(p), g.transferWrite(data)
+			}
+			if err := g.transferWrite(data[:end]); err != nil {
+				return 0, err
+			}
+			g.stop()
+			data = data[end:]
+			continue
+		}
+
+		start, upload := g.findStart(data)
+		if start < 0 {
+			// Hold back what could be the beginning of a split start
+			// marker, so the terminal receives it whole
+			keep := partialMatch(data, zmodemStart)
+			g.pending = append([]byte(nil), data[len(data)-keep:]...)
+			data = data[:len(data)-keep]
+			if len(data) == 0 {
+				break
+			}
+			_, err := g.translated.Write(data)
+			return len(p), err
+		}
+		if start > 0 {
+			if _, err := g.translated.Write(data[:start]); err != nil {
+				return 0, err
+			}
+		}
+		g.begin(upload)
+		data = data[start:]
+	}
+	return len(p), nil
+}
+
+// findStart returns the offset of the first start marker in data, or -1,
+// and whether it starts an upload. Uploads are only looked for when there
+// is an sz helper to answer them.
+func (g *zmodemGuard) findStart(data []byte) (int, bool) {
+	start := bytes.Index(data, zmodemStart)
+	if g.helper != nil && g.helper.canUpload() {
+		if i := bytes.Index(data, zmodemUpload); i >= 0 && (start < 0 || i < start) {
+			return i, true
+		}
+	}
+	return start, false
+}
+
+// begin switches to passthrough, handing the transfer to a helper if one
+// is configured for this direction.
+func (g *zmodemGuard) begin(upload bool) {
+	g.active.Store(true)
+	g.upload = upload
+	g.finishing = false
+	g.tail = nil
+
+	if g.helper == nil || !upload && g.helper.rz == "" {
+		return
+	}
+	transfer, err := g.helper.start(upload)
+	if err != nil {
+		// The terminal may still be able to handle it
+		g.errorf("Failed to start Zmodem helper: %v", err)
+		return
+	}
+	g.transfer = transfer
+}
+
+// stop ends passthrough, waiting for the helper to finish.
+func (g *zmodemGuard) stop() {
+	g.active.Store(false)
+	if g.transfer == nil {
+		return
+	}
+	if err := g.transfer.finish(); err != nil {
+		g.errorf("Zmodem helper failed: %v", err)
+	}
+	g.transfer = nil
+}
+
+// Close stops a helper left running when the session ends mid-transfer.
+func (g *zmodemGuard) Close() {
+	if g == nil || g.transfer == nil {
+		return
+	}
+	g.transfer.cmd.Process.Kill()
+	g.transfer.finish()
+	g.transfer = nil
+}
+
+// transferWrite passes transfer data to the helper, or to the terminal.
+func (g *zmodemGuard) transferWrite(p []byte) error {
+	if g.transfer != nil {
+		// A helper that exited early has already reported its error;
+		// the rest of the transfer is dropped until the server gives up
+		g.transfer.stdin.Write(p)
+		return nil
+	}
+	_, err := g.terminal.Write(p)
+	return err
+}
+
+// partialMatch returns the length of the longest suffix of data that is a
+// proper prefix of marker.
+func partialMatch(data, marker []byte) int {
+	for n := len(marker) - 1; n > 0; n-- {
+		if len(data) >= n && bytes.Equal(data[len(data)-n:], marker[:n]) {
+			return n
+		}
+	}
+	return 0
+}
+
+// transferEnd returns the offset in data just past the end of the transfer,
+// or -1 if it carries on beyond data.
+func (g *zmodemGuard) transferEnd(data []byte) int {
+	end := g.find(data, zmodemCancel)
+	if !g.finishing {
+		if finish := g.find(data, zmodemFinish); finish >= 0 && (end < 0 || finish < end) {
+			g.finishing = true
+			g.tail = nil
+			if g.upload {
+				// The sender's "OO" comes from the helper, not the server
+				return finish
+			}
+			if over := g.find(data[finish:], zmodemOver); over >= 0 {
+				return finish + over
+			}
+			return end
+		}
+		return end
+	}
+	if over := g.find(data, zmodemOver); over >= 0 && (end < 0 || over < end) {
+		return over
+	}
+	return end
+}
+
+// find returns the offset in data just past the first match of marker,
+// including matches that began in the previous write, or -1 if none.
+func (g *zmodemGuard) find(data, marker []byte) int {
+	combined := append(append([]byte(nil), g.tail...), data...)
+	i := bytes.Index(combined, marker)
+	if i < 0 {
+		return -1
+	}
+	end := i + len(marker) - len(g.tail)
+	if end <= 0 {
+		return -1
+	}
+	return end
+}
+
+// keepTail remembers enough of the transfer to match an end marker split
+// across writes.
+func (g *zmodemGuard) keepTail(p []byte) {
+	g.tail = append(g.tail, p...)
+	if keep := len(zmodemStart) - 1; len(g.tail) > keep {
+		g.tail = append([]byte(nil), g.tail[len(g.tail)-keep:]...)
+	}
+}
+
+// zmodemHelper runs the external lrzsz programs that carry out transfers.
+type zmodemHelper struct {
+	rz     string   // receive program, empty to leave downloads to the terminal
+	sz     string   // send program
+	dir    string   // where rz saves downloads
+	upload []string // files sz sends when the server asks for an upload
+	server io.Writer
+}
+
+func (h *zmodemHelper) canUpload() bool {
+	return h.sz != "" && len(h.upload) > 0
+}
+
+// zmodemTransfer is a running rz or sz, reading the server's side of the
+// transfer from stdin and writing its replies straight to the server.
+type zmodemTransfer struct {
+	cmd   *exec.Cmd
+	stdin io.WriteCloser
+}
+
+func (h *zmodemHelper) start(upload bool) (*zmodemTransfer, error) {
+	var cmd *exec.Cmd
+	if upload {
+		cmd = exec.Command(h.sz, h.upload...)
+	} else {
+		cmd = exec.Command(h.rz)
+		cmd.Dir = h.dir
+	}
+	cmd.Stdout = h.server
+	cmd.Stderr = os.Stderr // progress messages
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("%s: %v", cmd.Path, err)
+	}
+	return &zmodemTransfer{cmd: cmd, stdin: stdin}, nil
+}
+
+// finish closes the helper's input and waits for it to exit.
+func (x *zmodemTransfer) finish() error {
+	x.stdin.Close()
+	return x.cmd.Wait()
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+
+	"github.com/BurntSushi/toml"
+)
+
+// boardConfig holds the connection settings for one board in a config file.
+type boardConfig struct {
+	Host    string `toml:"host"`
+	Port    uint64 `toml:"port"`
+	Name    string `toml:"name"`
+	Tag     string `toml:"tag"`
+	Xtrn    string `toml:"xtrn"`
+	Timeout string `toml:"timeout"`
+}
+
+// configFile is the layout of a -config file. Top-level settings apply to
+// every profile; a [profiles.<name>] table overrides them for that board.
+type configFile struct {
+	Host     string                 `toml:"host"`
+	Port     uint64                 `toml:"port"`
+	Name     string                 `toml:"name"`
+	Tag      string                 `toml:"tag"`
+	Xtrn     string                 `toml:"xtrn"`
+	Timeout  string                 `toml:"timeout"`
+	Profiles map[string]boardConfig `toml:"profiles"`
+}
+
+// loadConfig reads the TOML file at path and returns its settings for the
+// given profile as flag name/value pairs. An empty profile uses only the
+// top-level settings.
+func loadConfig(path, profile string) (map[string]string, error) {
+	var config configFile
+	if _, err := toml.DecodeFile(path, &config); err != nil {
+		return nil, fmt.Errorf("error reading config file %q: %v", path, err)
+	}
+
+	board := config.defaults()
+	if profile != "" {
+		selected, ok := config.Profiles[profile]
+		if !ok {
+			return nil, fmt.Errorf("profile %q not found in config file %q", profile, path)
+		}
+		board.merge(selected)
+	}
+
+	return board.flagValues(), nil
+}
+
+// defaults returns the top-level settings shared by every profile.
+func (c configFile) defaults() boardConfig {
+	return boardConfig{
+		Host:    c.Host,
+		Port:    c.Port,
+		Name:    c.Name,
+		Tag:     c.Tag,
+		Xtrn:    c.Xtrn,
+		Timeout: c.Timeout,
+	}
+}
+
+// defaultConfigPath returns the bookmark file used by -connect and -list:
+// goldmine-connect/boards.toml in $XDG_CONFIG_HOME, or in ~/.config when
+// that isn't set. macOS and Windows use it too, rather than their own
+// settings folders, so the documented path works everywhere.
+func defaultConfigPath() (string, error) {
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		dir = filepath.Join(home, ".config")
+	}
+	return filepath.Join(dir, "goldmine-connect", "boards.toml"), nil
+}
+
+// listProfiles writes every profile in the config file at path, sorted by
+// name, along with the host and port it connects to.
+func listProfiles(path string, w io.Writer) error {
+	var config configFile
+	if _, err := toml.DecodeFile(path, &config); err != nil {
+		return fmt.Errorf("error reading config file %q: %v", path, err)
+	}
+
+	names := make([]string, 0, len(config.Profiles))
+	for name := range config.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		board := config.defaults()
+		board.merge(config.Profiles[name])
+		fmt.Fprintf(w, "%-20s %s:%d\n", name, board.Host, board.Port)
+	}
+	return nil
+}
+
+// merge overrides b with any settings present in other.
+func (b *boardConfig) merge(other boardConfig) {
+	if other.Host != "" {
+		b.Host = other.Host
+	}
+	if other.Port != 0 {
+		b.Port = other.Port
+	}
+	if other.Name != "" {
+		b.Name = other.Name
+	}
+	if other.Tag != "" {
+		b.Tag = other.Tag
+	}
+	if other.Xtrn != "" {
+		b.Xtrn = other.Xtrn
+	}
+	if other.Timeout != "" {
+		b.Timeout = other.Timeout
+	}
+}
+
+// flagValues returns the non-empty settings keyed by their command-line flag name.
+func (b boardConfig) flagValues() map[string]string {
+	values := make(map[string]string)
+	if b.Host != "" {
+		values["host"] = b.Host
+	}
+	if b.Port != 0 {
+		values["port"] = strconv.FormatUint(b.Port, 10)
+	}
+	if b.Name != "" {
+		values["name"] = b.Name
+	}
+	if b.Tag != "" {
+		values["tag"] = b.Tag
+	}
+	if b.Xtrn != "" {
+		values["xtrn"] = b.Xtrn
+	}
+	if b.Timeout != "" {
+		values["timeout"] = b.Timeout
+	}
+	return values
+}
+
+// applyConfig sets each flag from values unless it was given explicitly on
+// the command line, so explicit flags always win over the config file.
+func applyConfig(values map[string]string) error {
+	explicit := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+
+	for name, value := range values {
+		if explicit[name] {
+			continue
+		}
+		if err := flag.Set(name, value); err != nil {
+			return fmt.Errorf("invalid %s value %q in config file: %v", name, value, err)
+		}
+	}
+	return nil
+}
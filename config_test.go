@@ -0,0 +1,25 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestDefaultConfigPath(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("USERPROFILE", home)
+
+	t.Setenv("XDG_CONFIG_HOME", "")
+	want := filepath.Join(home, ".config", "goldmine-connect", "boards.toml")
+	if got, err := defaultConfigPath(); err != nil || got != want {
+		t.Errorf("defaultConfigPath() = %q, %v; want %q", got, err, want)
+	}
+
+	xdg := filepath.Join(home, "xdg")
+	t.Setenv("XDG_CONFIG_HOME", xdg)
+	want = filepath.Join(xdg, "goldmine-connect", "boards.toml")
+	if got, err := defaultConfigPath(); err != nil || got != want {
+		t.Errorf("defaultConfigPath() with XDG_CONFIG_HOME = %q, %v; want %q", got, err, want)
+	}
+}
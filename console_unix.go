@@ -0,0 +1,12 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import "os"
+
+// openConsole opens the controlling terminal for reading, independent of
+// where stdin is redirected from.
+func openConsole() (*os.File, error) {
+	return os.Open("/dev/tty")
+}
@@ -0,0 +1,12 @@
+//go:build windows
+// +build windows
+
+package main
+
+import "os"
+
+// openConsole opens the console input buffer for reading, independent of
+// where stdin is redirected from.
+func openConsole() (*os.File, error) {
+	return os.Open("CONIN$")
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+// envFlags maps each environment variable to the flag it provides a fallback for.
+var envFlags = []struct {
+	env, flag string
+}{
+	{"GOLDMINE_HOST", "host"},
+	{"GOLDMINE_PORT", "port"},
+	{"GOLDMINE_NAME", "name"},
+	{"GOLDMINE_TAG", "tag"},
+	{"GOLDMINE_XTRN", "xtrn"},
+	{"GOLDMINE_TIMEOUT", "timeout"},
+}
+
+// applyEnv sets each flag from its GOLDMINE_* environment variable unless the
+// flag was given explicitly on the command line.
+func applyEnv() error {
+	explicit := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+
+	for _, e := range envFlags {
+		value, ok := os.LookupEnv(e.env)
+		if !ok || value == "" || explicit[e.flag] {
+			continue
+		}
+
+		switch e.flag {
+		case "port":
+			if _, err := strconv.ParseUint(value, 10, 64); err != nil {
+				return fmt.Errorf("invalid %s value %q: must be a port number", e.env, value)
+			}
+		case "timeout":
+			if _, err := time.ParseDuration(value); err != nil {
+				return fmt.Errorf("invalid %s value %q: must be a duration such as 1s or 500ms", e.env, value)
+			}
+		}
+
+		if err := flag.Set(e.flag, value); err != nil {
+			return fmt.Errorf("invalid %s value %q: %v", e.env, value, err)
+		}
+	}
+	return nil
+}
+
+// outputEnvDefaults reports how the environment changes the output
+// defaults: plain when TERM is dumb or set but empty; noColor when
+// NO_COLOR is set to anything (https://no-color.org). An unset TERM says
+// nothing about the terminal, so it is left alone.
+func outputEnvDefaults() (plain, noColor bool) {
+	term, ok := os.LookupEnv("TERM")
+	plain = term == "dumb" || ok && term == ""
+	noColor = os.Getenv("NO_COLOR") != ""
+	return plain, noColor
+}
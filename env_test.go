@@ -0,0 +1,29 @@
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+func TestOutputEnvDefaults(t *testing.T) {
+	tests := []struct {
+		term      string
+		unset     bool
+		wantPlain bool
+	}{
+		{term: "xterm-256color", wantPlain: false},
+		{term: "dumb", wantPlain: true},
+		{term: "", wantPlain: true},
+		{unset: true, wantPlain: false},
+	}
+
+	for _, tt := range tests {
+		t.Setenv("TERM", tt.term)
+		if tt.unset {
+			os.Unsetenv("TERM")
+		}
+		if plain, _ := outputEnvDefaults(); plain != tt.wantPlain {
+			t.Errorf("TERM %q (unset %v): plain = %v, want %v", tt.term, tt.unset, plain, tt.wantPlain)
+		}
+	}
+}
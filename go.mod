@@ -1,5 +1,11 @@
 module github.com/robbiew/goldmine-connect
 
-go 1.15
+go 1.21
 
-require golang.org/x/term v0.27.0
+require (
+	github.com/BurntSushi/toml v1.4.0
+	golang.org/x/net v0.33.0
+	golang.org/x/term v0.27.0
+)
+
+require golang.org/x/sys v0.28.0 // indirect
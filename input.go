@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// fifoReader reads keystrokes from a named pipe. When a writer closes its
+// end the pipe is reopened, waiting for the next writer, so the input
+// never ends just because one controlling process finished.
+type fifoReader struct {
+	path string
+	file *os.File
+}
+
+// openInputFifo checks that path is a named pipe and returns a reader for
+// it. The pipe itself is opened on the first read, which waits for a writer.
+func openInputFifo(path string) (*fifoReader, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if info.Mode()&os.ModeNamedPipe == 0 {
+		return nil, fmt.Errorf("%s is not a named pipe (create one with mkfifo)", path)
+	}
+	return &fifoReader{path: path}, nil
+}
+
+func (f *fifoReader) Read(p []byte) (int, error) {
+	for {
+		if f.file == nil {
+			file, err := os.Open(f.path)
+			if err != nil {
+				return 0, err
+			}
+			f.file = file
+		}
+
+		n, err := f.file.Read(p)
+		if err == io.EOF {
+			// The writer went away; wait for the next one
+			f.file.Close()
+			f.file = nil
+			if n == 0 {
+				continue
+			}
+			err = nil
+		}
+		return n, err
+	}
+}
+
+// mergeInputs returns a reader that interleaves what is read from each of
+// sources, as it arrives. It reaches EOF once every source has, and fails
+// with the first error any source returns.
+func mergeInputs(sources ...io.Reader) io.Reader {
+	reader, writer := io.Pipe()
+	var wg sync.WaitGroup
+	for _, source := range sources {
+		wg.Add(1)
+		go func(source io.Reader) {
+			defer wg.Done()
+			// Writes to a pipe are serialised, so chunks aren't mixed
+			if _, err := io.Copy(writer, source); err != nil {
+				writer.CloseWithError(err)
+			}
+		}(source)
+	}
+	go func() {
+		wg.Wait()
+		writer.Close()
+	}()
+	return reader
+}
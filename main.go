@@ -1,301 +1,177 @@
 package main
 
 import (
-	"bufio"
-	"bytes"
-	"flag"
+	"context"
 	"fmt"
 	"io"
 	"log"
-	"net"
+	"log/slog"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
+	"github.com/robbiew/goldmine-connect/client"
 	"golang.org/x/term"
 )
 
-const defaultBufferSize = 4096
-const sleepBufferFullMilli = 250
-
-// CommandLine struct stores command-line arguments.
-type CommandLine struct {
-	host    string
-	port    uint64
-	name    string
-	tag     *string
-	xtrn    *string
-	timeout time.Duration
-	pass    *string
-}
-
-// Read method parses command line args using the flag package.
-func Read() *CommandLine {
-	host := flag.String("host", "", "GoldMine host address")
-	port := flag.Uint64("port", 0, "Goldmine rlogin port")
-	name := flag.String("name", "", "Username")
-	tag := flag.String("tag", "", "BBS tag (no brackets)")
-	xtrn := flag.String("xtrn", "", "Gold Mine xtrn code (optional)") // Optional flag
-	pass := flag.String("password", "", "Password (optional)")
-	timeout := flag.Duration("timeout", 1*time.Second, "Byte receiving timeout after the input EOF occurs")
-
-	flag.Parse()
-
-	// Validate required flags
-	if *host == "" || *port == 0 || *name == "" {
-		log.Fatalf(`Error: Missing required arguments.
-Usage: goldmine-connect -host <host> -port <port> -name <username> [-password <password>] [-tag <BBS tag>] [-xtrn <xtrn code>] [-timeout <timeout>]
-
-Example: goldmine-connect -host example.com -port 2513 -name myUsername -tag myBBS
-
-Required arguments:
-  -host    The GoldMine host address to connect to.
-  -port    The GoldMine rlogin port number.
-  -name    Your username for the connection.
-
-Optional arguments:
-  -tag      The BBS tag (without brackets).
-  -xtrn     Optional Gold Mine xtrn code.
-  -password Optional Password
-  -timeout  Byte receiving timeout, e.g., 1s, 500ms (default: 1s).`)
-	}
-
-	return &CommandLine{
-		host:    *host,
-		port:    *port,
-		name:    *name,
-		tag:     tag,
-		xtrn:    xtrn,
-		timeout: *timeout,
-		pass:    pass,
-	}
-}
-
-// Options interface defines the client settings.
-type Options interface {
-	Host() string
-	Port() uint64
-	Timeout() time.Duration
-	Name() string
-	Xtrn() *string
-	Tag() *string
-	Pass() *string
-}
-
-// Implementing Options interface methods for CommandLine
-func (c *CommandLine) Host() string           { return c.host }
-func (c *CommandLine) Port() uint64           { return c.port }
-func (c *CommandLine) Timeout() time.Duration { return c.timeout }
-func (c *CommandLine) Name() string           { return c.name }
-func (c *CommandLine) Xtrn() *string          { return c.xtrn }
-func (c *CommandLine) Tag() *string           { return c.tag }
-func (c *CommandLine) Pass() *string          { return c.pass }
+// Build information, set at link time with -ldflags "-X main.version=...".
+var (
+	version = "dev"
+	commit  = "unknown"
+	date    = "unknown"
+)
 
-// TelnetClient represents a TCP client which is responsible for writing input data and printing response.
-type TelnetClient struct {
-	destination     *net.TCPAddr
-	responseTimeout time.Duration
-}
+// Main function
+func main() {
+	commandLine := Read()
 
-// NewTelnetClient creates a new TelnetClient instance.
-func NewTelnetClient(options Options) (*TelnetClient, error) {
-	tcpAddr := createTCPAddr(options)
-	resolved, err := resolveTCPAddr(tcpAddr)
-	if err != nil {
-		return nil, err
+	// Playback bypasses the network client entirely
+	if commandLine.Play() != "" {
+		if err := client.PlayCast(commandLine.Play(), os.Stdout, commandLine.PlaySpeed(), commandLine.PlayFrom()); err != nil {
+			log.Fatalf("Playback failed: %v", err)
+		}
+		return
 	}
 
-	return &TelnetClient{
-		destination:     resolved,
-		responseTimeout: options.Timeout(),
-	}, nil
-}
-
-// ProcessData method establishes a connection to the server and processes input/output data.
-func (t *TelnetClient) ProcessData(inputData io.Reader, outputData io.Writer, options Options) {
-	connection, err := net.DialTCP("tcp", nil, t.destination)
-	if err != nil {
-		log.Fatalf("Error occurred while connecting to address \"%v\": %v\n", t.destination.String(), err)
+	if commandLine.DryRun() {
+		if commandLine.RawTelnet() {
+			fmt.Println("No handshake is sent with -raw-telnet.")
+			return
+		}
+		if err := client.DescribeHandshake(os.Stdout, commandLine); err != nil {
+			usageFatalf("Error: %v", err)
+		}
 		return
 	}
-	defer func() {
-		connection.Close()
-		log.Println("Connection closed.")
-	}()
 
-	// Conditionally include xtrn if it's provided
-	localUsername := ""              // Placeholder: replace with actual local username if needed
-	remoteUsername := options.Name() // Use the name from CommandLine struct
-
-	if options.Pass() != nil && *options.Pass() != "" {
-		localUsername = *options.Pass()
+	// JSON logging also takes over the log package, so every message on
+	// stderr is a JSON object
+	var structured *slog.Logger
+	if commandLine.LogFormat() == "json" {
+		structured = slog.New(slog.NewJSONHandler(os.Stderr, nil))
+		slog.SetDefault(structured)
 	}
 
-	handshake := ""
-	if options.Tag() != nil && *options.Tag() != "" {
-		tag := options.Tag()
-		handshake += fmt.Sprintf("\x00%s\x00[%s]%s\x00", localUsername, *tag, remoteUsername)
-	} else {
-		handshake += fmt.Sprintf("\x00%s\x00%s\x00", localUsername, remoteUsername)
-	}
-	// Check if xtrn (termtype) is provided
-	if options.Xtrn() != nil && *options.Xtrn() != "" {
-		handshake += "xtrn=" + *options.Xtrn() + "\x00"
-	} else {
-		// Send an empty string followed by a null character for termtype if not provided
-		handshake += "\x00"
+	telnetClient, err := client.NewTelnetClient(commandLine)
+	if err != nil {
+		log.Printf("Failed to create TelnetClient: %v", err)
+		os.Exit(client.ExitCode(err))
 	}
-
-	// Write handshake to the connection
-	if _, err := connection.Write([]byte(handshake)); err != nil {
-		log.Fatalf("Failed to send rlogin handshake: %v", err)
-		return
+	if structured != nil {
+		telnetClient.SetStructuredLogger(structured)
 	}
 
-	nullbuf := make([]byte, 1)
-
-	if _, err := connection.Read(nullbuf); err != nil {
-		log.Fatalf("Did not receive null byte")
-		return
+	if commandLine.MetricsAddr() != "" {
+		metrics := &client.SessionMetrics{}
+		if err := client.ServeMetrics(commandLine.MetricsAddr(), metrics); err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+		telnetClient.SetMetrics(metrics)
 	}
 
-	if nullbuf[0] != '\x00' {
-		log.Fatalf("Did not receive null byte")
+	// A -golden run only connects long enough to capture the handshake
+	// and the start of the response
+	if commandLine.Golden() != "" {
+		err := telnetClient.RunGolden(commandLine.Golden(), commandLine.GoldenBytes(), commandLine.GoldenUpdate())
+		if code := client.ExitCode(err); code != client.ExitOK {
+			log.Printf("Golden check failed: %v", err)
+			os.Exit(code)
+		}
 		return
 	}
 
-	requestDataChannel := make(chan []byte)
-	doneChannel := make(chan bool)
-	responseDataChannel := make(chan []byte)
-	closeSignal := make(chan bool) // Channel to signal server disconnection
-	closing := false               // Flag to indicate if we're closing
+	// With -keep-open, piped input is followed by the terminal, so the
+	// session carries on interactively instead of closing at EOF
+	var input io.Reader = os.Stdin
+	inputFd := int(os.Stdin.Fd())
 
-	// Start data handling goroutines
-	go t.readInputData(inputData, requestDataChannel, doneChannel)
-	go t.readServerData(connection, responseDataChannel, closeSignal)
-
-	afterEOFResponseTicker := time.NewTicker(t.responseTimeout)
-	defer afterEOFResponseTicker.Stop()
-
-	var afterEOFMode bool
-	var somethingRead bool
-
-	for {
-		select {
-		case request := <-requestDataChannel:
-			if closing {
-				log.Println("Connection closing; stopping writes.")
-				return
-			}
-			if _, err := connection.Write(request); err != nil {
-				log.Printf("Error occurred while writing to TCP socket: %v\n", err)
-				return
-			}
-		case <-doneChannel:
-			afterEOFMode = true
-			closing = true // Set closing flag
-		case response := <-responseDataChannel:
-			if closing {
-				log.Println("Connection closing; stopping reads.")
-				return
-			}
-			outputData.Write(response)
-			somethingRead = true
-			if afterEOFMode {
-				afterEOFResponseTicker.Stop()
-				afterEOFResponseTicker = time.NewTicker(t.responseTimeout)
-			}
-		case <-afterEOFResponseTicker.C:
-			if afterEOFMode && !somethingRead {
-				log.Println("Connection timeout with no response received.")
-				return
-			}
-		case <-closeSignal:
-			log.Println("Server disconnected. Exiting.")
-			return
+	if commandLine.KeepOpen() && !term.IsTerminal(inputFd) {
+		console, err := openConsole()
+		if err != nil {
+			log.Fatalf("Error: -keep-open could not open the terminal: %v", err)
 		}
+		defer console.Close()
+		input = io.MultiReader(os.Stdin, console)
+		inputFd = int(console.Fd())
 	}
-}
 
-func (t *TelnetClient) readInputData(inputData io.Reader, toSend chan<- []byte, doneChannel chan<- bool) {
-	buffer := make([]byte, defaultBufferSize)
-	reader := bufio.NewReader(inputData)
-
-	for {
-		n, err := reader.Read(buffer)
+	// Another process can type into the session through -input-fifo,
+	// alongside the keyboard
+	if commandLine.InputFifo() != "" {
+		fifo, err := openInputFifo(commandLine.InputFifo())
 		if err != nil {
-			if err == io.EOF {
-				doneChannel <- true
-				return
-			}
-			log.Fatalf("Error reading input data: %v", err)
+			log.Fatalf("Error: -input-fifo: %v", err)
 		}
-		// Send raw data
-		toSend <- buffer[:n]
+		input = mergeInputs(input, fifo)
 	}
-}
 
-func (t *TelnetClient) readServerData(connection *net.TCPConn, received chan<- []byte, closeSignal chan<- bool) {
-	buffer := make([]byte, defaultBufferSize)
+	// Raw mode passes single keystrokes (arrows, hotkeys) straight through.
+	// It only applies when the input is an interactive terminal.
+	var ts *term.State
 
-	for {
-		n, err := connection.Read(buffer)
+	if commandLine.Raw() && term.IsTerminal(inputFd) {
+		ts, err = term.MakeRaw(inputFd)
 		if err != nil {
-			if err == io.EOF {
-				log.Println("Server closed the connection.")
-				closeSignal <- true
-				close(received)
-				return
-			}
-			log.Printf("Error occurred while reading from server: %v\n", err)
-			closeSignal <- true
-			close(received)
-			return
+			log.Printf("Could not put terminal into raw mode: %v\n", err)
 		}
-		// Send raw bytes as-is
-		received <- buffer[:n]
+	}
+	telnetClient.SetRawTerminal(ts != nil)
 
-		if n == defaultBufferSize {
-			time.Sleep(sleepBufferFullMilli * time.Millisecond)
+	restoreTerminal := func() {
+		if ts != nil {
+			term.Restore(inputFd, ts)
 		}
 	}
-}
+	defer restoreTerminal()
 
-// createTCPAddr builds a TCP address string.
-func createTCPAddr(options Options) string {
-	var buffer bytes.Buffer
-	buffer.WriteString(options.Host())
-	buffer.WriteByte(':')
-	buffer.WriteString(fmt.Sprintf("%d", options.Port()))
-	return buffer.String()
-}
+	// The first SIGINT/SIGTERM closes the session cleanly; a second one
+	// force-exits in case the clean shutdown hangs.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 
-// resolveTCPAddr resolves a TCP address string.
-func resolveTCPAddr(addr string) (*net.TCPAddr, error) {
-	resolved, err := net.ResolveTCPAddr("tcp", addr)
-	if err != nil {
-		return nil, fmt.Errorf("error occurred while resolving TCP address \"%v\": %v", addr, err)
-	}
-	return resolved, nil
-}
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
 
-// Main function
-func main() {
-	commandLine := Read()
+	// Writing to a closed stdout pipe should end the session with an
+	// error, not kill the process with SIGPIPE before it can clean up
+	signal.Ignore(syscall.SIGPIPE)
+	go func() {
+		<-signals
+		if !commandLine.Quiet() {
+			log.Println("Interrupted, closing connection...")
+		}
+		cancel()
+		<-signals
+		restoreTerminal()
+		log.Println("Interrupted again, exiting immediately.")
+		os.Exit(1)
+	}()
 
-	telnetClient, err := NewTelnetClient(commandLine)
-	if err != nil {
-		log.Fatalf("Failed to create TelnetClient: %v", err)
+	stats, err := telnetClient.Run(ctx, input, os.Stdout)
+	if !commandLine.Quiet() {
+		if structured != nil {
+			structured.Info("session summary", "bytes_sent", stats.BytesSent, "bytes_received", stats.BytesReceived,
+				"duration", stats.Duration.Round(time.Second).String(), "reconnects", stats.ReconnectCount)
+		} else {
+			log.Printf("Session summary: %v\n", stats)
+		}
 	}
 
-	var ts *term.State
-
-	if term.IsTerminal(int(os.Stdout.Fd())) {
-		ts, _ = term.MakeRaw(int(os.Stdout.Fd()))
+	if code := client.ExitCode(err); code != client.ExitOK {
+		// os.Exit skips deferred calls, so restore the terminal first
+		restoreTerminal()
+		if structured != nil {
+			structured.Error("session failed", "error", err.Error(), "exit_code", code)
+		} else {
+			log.Printf("Session failed: %v", err)
+		}
+		os.Exit(code)
 	}
+}
 
-	telnetClient.ProcessData(os.Stdin, os.Stdout, commandLine)
-
-	if term.IsTerminal(int(os.Stdout.Fd())) {
-		term.Restore(int(os.Stdout.Fd()), ts)
-	}
+// usageFatalf logs an argument error and exits with client.ExitUsage.
+func usageFatalf(format string, v ...interface{}) {
+	log.Printf(format, v...)
+	os.Exit(client.ExitUsage)
 }
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/robbiew/goldmine-connect/client"
+)
+
+// promptMissing asks on the terminal for whichever of the connection
+// settings weren't given, so the client can be started with no arguments.
+// Questions go to stderr and answers are read from stdin. The tag is
+// optional, and so is the name with -raw-telnet; it is only asked for
+// along with other settings.
+func promptMissing(host *string, port *uint64, name, tag *string, rawTelnet bool) error {
+	input := bufio.NewReader(os.Stdin)
+	asked := false
+
+	for *host == "" {
+		asked = true
+		answer, err := ask(input, "Host: ")
+		if err != nil {
+			return err
+		}
+		*host = answer
+	}
+
+	for *port == 0 && client.NeedsPort(*host) {
+		asked = true
+		answer, err := ask(input, "Port: ")
+		if err != nil {
+			return err
+		}
+		n, err := strconv.ParseUint(answer, 10, 64)
+		if err != nil || n < 1 || n > 65535 {
+			fmt.Fprintln(os.Stderr, "The port must be a number from 1 to 65535.")
+			continue
+		}
+		*port = n
+	}
+
+	for *name == "" && !rawTelnet {
+		asked = true
+		answer, err := ask(input, "Username: ")
+		if err != nil {
+			return err
+		}
+		*name = answer
+	}
+
+	if asked && *tag == "" {
+		answer, err := ask(input, "BBS tag (optional): ")
+		if err != nil {
+			return err
+		}
+		*tag = answer
+	}
+	return nil
+}
+
+// ask prints prompt and returns the line typed in reply, trimmed.
+func ask(input *bufio.Reader, prompt string) (string, error) {
+	fmt.Fprint(os.Stderr, prompt)
+	line, err := input.ReadString('\n')
+	if err != nil && !(err == io.EOF && line != "") {
+		if err == io.EOF {
+			return "", errors.New("input ended before all settings were given")
+		}
+		return "", err
+	}
+	return strings.TrimSpace(line), nil
+}
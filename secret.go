@@ -0,0 +1,50 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+// readSecret returns the secret from path, or prompts for it on the
+// terminal with echo off. It returns "" when neither is requested.
+func readSecret(path string, prompt bool) (string, error) {
+	switch {
+	case path != "" && prompt:
+		return "", errors.New("use either -secret-file or -prompt-secret, not both")
+	case path != "":
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("error reading secret file: %v", err)
+		}
+		return strings.TrimRight(string(data), "\r\n"), nil
+	case prompt:
+		return promptSecret()
+	}
+	return "", nil
+}
+
+// promptSecret reads a line from the terminal without echoing it, using the
+// controlling terminal if stdin is redirected.
+func promptSecret() (string, error) {
+	input := os.Stdin
+	if !term.IsTerminal(int(input.Fd())) {
+		console, err := openConsole()
+		if err != nil {
+			return "", fmt.Errorf("-prompt-secret needs a terminal: %v", err)
+		}
+		defer console.Close()
+		input = console
+	}
+
+	fmt.Fprint(os.Stderr, "Secret: ")
+	secret, err := term.ReadPassword(int(input.Fd()))
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", fmt.Errorf("error reading secret: %v", err)
+	}
+	return string(secret), nil
+}